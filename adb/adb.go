@@ -0,0 +1,168 @@
+package adb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// Device states reported by `adb devices`.
+const (
+	StateDevice       = "device"
+	StateOffline      = "offline"
+	StateUnauthorized = "unauthorized"
+)
+
+// Device ...
+type Device struct {
+	Serial      string
+	State       string
+	Product     string
+	Model       string
+	Device      string
+	USB         string
+	TransportID string
+}
+
+// IsOnline ...
+func (device Device) IsOnline() bool {
+	return device.State == StateDevice
+}
+
+func (device Device) String() string {
+	s := fmt.Sprintf("%s (%s)", device.Serial, device.State)
+	if device.Model != "" {
+		s += " model: " + device.Model
+	}
+	return s
+}
+
+// ParseDevices parses the output of `adb devices -l`.
+func ParseDevices(out string) []Device {
+	devices := []Device{}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// skip the header and the daemon messages, like: * daemon started successfully
+		if line == "" || strings.HasPrefix(line, "List of devices") || strings.HasPrefix(line, "*") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		device := Device{
+			Serial: fields[0],
+			State:  fields[1],
+		}
+		for _, field := range fields[2:] {
+			split := strings.SplitN(field, ":", 2)
+			if len(split) != 2 {
+				continue
+			}
+			switch split[0] {
+			case "product":
+				device.Product = split[1]
+			case "model":
+				device.Model = split[1]
+			case "device":
+				device.Device = split[1]
+			case "usb":
+				device.USB = split[1]
+			case "transport_id":
+				device.TransportID = split[1]
+			}
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices
+}
+
+// OnlineDevices ...
+func OnlineDevices(devices []Device) []Device {
+	online := []Device{}
+	for _, device := range devices {
+		if device.IsOnline() {
+			online = append(online, device)
+		}
+	}
+	return online
+}
+
+// DeviceList returns a printable list of the given devices.
+func DeviceList(devices []Device) string {
+	if len(devices) == 0 {
+		return "no devices attached"
+	}
+
+	lines := []string{}
+	for _, device := range devices {
+		lines = append(lines, "- "+device.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FindDevice returns the online device with the given serial.
+func FindDevice(devices []Device, serial string) (Device, error) {
+	for _, device := range devices {
+		if device.Serial != serial {
+			continue
+		}
+		if !device.IsOnline() {
+			return Device{}, fmt.Errorf("device %s is %s, available devices:\n%s", serial, device.State, DeviceList(devices))
+		}
+		return device, nil
+	}
+	return Device{}, fmt.Errorf("device %s not found, available devices:\n%s", serial, DeviceList(devices))
+}
+
+// Model ...
+type Model struct {
+	pth    string
+	serial string
+}
+
+// New looks up adb in the platform-tools of the given Android home, or in the PATH.
+func New(androidHome string) (*Model, error) {
+	if androidHome != "" {
+		pth := filepath.Join(androidHome, "platform-tools", "adb")
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return nil, err
+		} else if exist {
+			return &Model{pth: pth}, nil
+		}
+	}
+
+	pth, err := exec.LookPath("adb")
+	if err != nil {
+		return nil, errors.New("adb not found in the Android home and in the PATH")
+	}
+	return &Model{pth: pth}, nil
+}
+
+// WithSerial returns an adb which targets the device with the given serial.
+func (adb Model) WithSerial(serial string) *Model {
+	return &Model{
+		pth:    adb.pth,
+		serial: serial,
+	}
+}
+
+// Command returns an adb command, which targets the selected device if any.
+func (adb Model) Command(args ...string) *command.Model {
+	if adb.serial != "" {
+		args = append([]string{"-s", adb.serial}, args...)
+	}
+	return command.New(adb.pth, args...)
+}
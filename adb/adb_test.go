@@ -0,0 +1,65 @@
+package adb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDevices(t *testing.T) {
+	out := `* daemon not running; starting now at tcp:5037
+* daemon started successfully
+List of devices attached
+emulator-5554          device product:sdk_gphone_x86 model:sdk_gphone_x86 device:generic_x86 transport_id:1
+0123456789ABCDEF       unauthorized usb:1-1 transport_id:2
+192.168.56.101:5555    offline transport_id:3
+R58M123ABC             device usb:2-1 product:beyond1lteeea model:SM_G973F device:beyond1 transport_id:4
+
+`
+
+	want := []Device{
+		{Serial: "emulator-5554", State: StateDevice, Product: "sdk_gphone_x86", Model: "sdk_gphone_x86", Device: "generic_x86", TransportID: "1"},
+		{Serial: "0123456789ABCDEF", State: StateUnauthorized, USB: "1-1", TransportID: "2"},
+		{Serial: "192.168.56.101:5555", State: StateOffline, TransportID: "3"},
+		{Serial: "R58M123ABC", State: StateDevice, Product: "beyond1lteeea", Model: "SM_G973F", Device: "beyond1", USB: "2-1", TransportID: "4"},
+	}
+	devices := ParseDevices(out)
+	if !reflect.DeepEqual(devices, want) {
+		t.Fatalf("ParseDevices() = %+v, want: %+v", devices, want)
+	}
+
+	var online []string
+	for _, device := range OnlineDevices(devices) {
+		online = append(online, device.Serial)
+	}
+	if want := []string{"emulator-5554", "R58M123ABC"}; !reflect.DeepEqual(online, want) {
+		t.Errorf("OnlineDevices() = %v, want: %v", online, want)
+	}
+}
+
+func TestParseDevicesWithoutDetails(t *testing.T) {
+	for out, want := range map[string][]Device{
+		"":                             {},
+		"List of devices attached\n\n": {},
+		// adb devices without -l prints the serial and the state only
+		"List of devices attached\nemulator-5556\tdevice\n": {{Serial: "emulator-5556", State: StateDevice}},
+	} {
+		if devices := ParseDevices(out); !reflect.DeepEqual(devices, want) {
+			t.Errorf("ParseDevices(%q) = %+v, want: %+v", out, devices, want)
+		}
+	}
+}
+
+func TestFindDevice(t *testing.T) {
+	devices := ParseDevices("List of devices attached\nemulator-5554 device\nemulator-5556 offline\n")
+
+	if device, err := FindDevice(devices, "emulator-5554"); err != nil || device.Serial != "emulator-5554" {
+		t.Errorf("FindDevice(emulator-5554) = %+v, %v", device, err)
+	}
+	if _, err := FindDevice(devices, "emulator-5556"); err == nil || !strings.Contains(err.Error(), "device emulator-5556 is offline") {
+		t.Errorf("FindDevice(emulator-5556) error = %v, want offline", err)
+	}
+	if _, err := FindDevice(devices, "emulator-5558"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("FindDevice(emulator-5558) error = %v, want not found", err)
+	}
+}
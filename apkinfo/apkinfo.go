@@ -0,0 +1,208 @@
+package apkinfo
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// InternetPermission ...
+const InternetPermission = "android.permission.INTERNET"
+
+// Manifest holds the information of an APK's AndroidManifest.xml.
+type Manifest struct {
+	PackageName        string
+	VersionCode        int
+	VersionName        string
+	MinSDKVersion      int
+	TargetSDKVersion   int
+	Permissions        []string
+	Debuggable         bool
+	LaunchableActivity string
+}
+
+// HasPermission ...
+func (manifest Manifest) HasPermission(permission string) bool {
+	for _, p := range manifest.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func readZipFile(reader *zip.ReadCloser, name string) ([]byte, error) {
+	for _, file := range reader.File {
+		if file.Name != name {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(rc)
+		if cerr := rc.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		return content, err
+	}
+	return nil, nil
+}
+
+// ReadManifest decodes the binary AndroidManifest.xml of the APK,
+// resource references are resolved with the APK's resources.arsc.
+func ReadManifest(apkPth string) (Manifest, error) {
+	reader, err := zip.OpenReader(apkPth)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	manifestContent, manifestErr := readZipFile(reader, "AndroidManifest.xml")
+	tableContent, tableErr := readZipFile(reader, "resources.arsc")
+	if err := reader.Close(); err != nil {
+		return Manifest{}, err
+	}
+
+	if manifestErr != nil {
+		return Manifest{}, fmt.Errorf("failed to read AndroidManifest.xml, error: %s", manifestErr)
+	}
+	if manifestContent == nil {
+		return Manifest{}, errors.New("no AndroidManifest.xml found in the apk")
+	}
+
+	var table *resourceTable
+	if tableErr != nil {
+		return Manifest{}, fmt.Errorf("failed to read resources.arsc, error: %s", tableErr)
+	}
+	if tableContent != nil {
+		if table, err = parseResourceTable(tableContent); err != nil {
+			return Manifest{}, fmt.Errorf("failed to parse resources.arsc, error: %s", err)
+		}
+	}
+
+	root, err := parseXML(manifestContent)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse AndroidManifest.xml, error: %s", err)
+	}
+
+	return newManifest(root, table)
+}
+
+func newManifest(root *xmlElement, table *resourceTable) (Manifest, error) {
+	if root.name != "manifest" {
+		return Manifest{}, fmt.Errorf("unexpected root element: %s", root.name)
+	}
+
+	resolver := valueResolver{table: table}
+	manifest := Manifest{
+		PackageName: resolver.stringAttr(root, "package"),
+		VersionCode: resolver.intAttr(root, "versionCode"),
+		VersionName: resolver.stringAttr(root, "versionName"),
+		Permissions: []string{},
+	}
+
+	for _, usesSDK := range root.childrenNamed("uses-sdk") {
+		manifest.MinSDKVersion = resolver.intAttr(usesSDK, "minSdkVersion")
+		manifest.TargetSDKVersion = resolver.intAttr(usesSDK, "targetSdkVersion")
+	}
+	if manifest.MinSDKVersion == 0 {
+		// the platform default if uses-sdk or minSdkVersion is missing
+		manifest.MinSDKVersion = 1
+	}
+	if manifest.TargetSDKVersion == 0 {
+		manifest.TargetSDKVersion = manifest.MinSDKVersion
+	}
+
+	for _, name := range []string{"uses-permission", "uses-permission-sdk-23", "uses-permission-sdk-m"} {
+		for _, permission := range root.childrenNamed(name) {
+			if permissionName := resolver.stringAttr(permission, "name"); permissionName != "" {
+				manifest.Permissions = append(manifest.Permissions, permissionName)
+			}
+		}
+	}
+
+	for _, application := range root.childrenNamed("application") {
+		manifest.Debuggable = resolver.stringAttr(application, "debuggable") == "true"
+		manifest.LaunchableActivity = launchableActivity(application, manifest.PackageName, resolver)
+	}
+
+	return manifest, nil
+}
+
+// launchableActivity returns the first activity (or alias) with a MAIN action and a LAUNCHER category intent filter.
+func launchableActivity(application *xmlElement, packageName string, resolver valueResolver) string {
+	activities := append(application.childrenNamed("activity"), application.childrenNamed("activity-alias")...)
+	for _, activity := range activities {
+		for _, filter := range activity.childrenNamed("intent-filter") {
+			hasMain, hasLauncher := false, false
+			for _, action := range filter.childrenNamed("action") {
+				hasMain = hasMain || resolver.stringAttr(action, "name") == "android.intent.action.MAIN"
+			}
+			for _, category := range filter.childrenNamed("category") {
+				hasLauncher = hasLauncher || resolver.stringAttr(category, "name") == "android.intent.category.LAUNCHER"
+			}
+			if !hasMain || !hasLauncher {
+				continue
+			}
+
+			name := resolver.stringAttr(activity, "name")
+			if strings.HasPrefix(name, ".") {
+				name = packageName + name
+			} else if !strings.Contains(name, ".") && name != "" {
+				name = packageName + "." + name
+			}
+			return name
+		}
+	}
+	return ""
+}
+
+// valueResolver converts typed values to strings, following references into the resource table.
+type valueResolver struct {
+	table *resourceTable
+}
+
+func (resolver valueResolver) stringAttr(element *xmlElement, name string) string {
+	v, ok := element.attr(name)
+	if !ok {
+		return ""
+	}
+	return resolver.stringValue(v, 0)
+}
+
+func (resolver valueResolver) intAttr(element *xmlElement, name string) int {
+	i, err := strconv.ParseInt(resolver.stringAttr(element, name), 0, 64)
+	if err != nil {
+		return 0
+	}
+	return int(i)
+}
+
+func (resolver valueResolver) stringValue(v value, depth int) string {
+	switch v.dataType {
+	case typeString:
+		return v.raw
+	case typeIntDec:
+		return strconv.FormatInt(int64(int32(v.data)), 10)
+	case typeIntHex:
+		return fmt.Sprintf("0x%x", v.data)
+	case typeBoolean:
+		return strconv.FormatBool(v.data != 0)
+	case typeReference:
+		// references may point to other references, stop at a reasonable depth to avoid cycles
+		if depth > 8 {
+			return ""
+		}
+		referenced, ok := resolver.table.lookup(v.data)
+		if !ok {
+			return fmt.Sprintf("@0x%08x", v.data)
+		}
+		return resolver.stringValue(referenced, depth+1)
+	default:
+		return v.raw
+	}
+}
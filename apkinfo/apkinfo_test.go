@@ -0,0 +1,142 @@
+package apkinfo
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+// testdata/app.apk is a minimal apk: a binary AndroidManifest.xml of com.example.app
+// with a versionName referencing the string resource @string/app_version (1.2.3) of its resources.arsc.
+const testAPK = "testdata/app.apk"
+
+func readTestZipFile(t *testing.T, name string) []byte {
+	t.Helper()
+
+	reader, err := zip.OpenReader(testAPK)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", testAPK, err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			t.Errorf("failed to close %s: %s", testAPK, err)
+		}
+	}()
+
+	content, err := readZipFile(reader, name)
+	if err != nil || content == nil {
+		t.Fatalf("failed to read %s of %s: %v", name, testAPK, err)
+	}
+	return content
+}
+
+func writeTestAPK(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+
+	pth := filepath.Join(t.TempDir(), "app.apk")
+	file, err := os.Create(pth)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", pth, err)
+	}
+
+	writer := zip.NewWriter(file)
+	for name, content := range files {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %s", name, err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatalf("failed to write %s: %s", name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to write %s: %s", pth, err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("failed to close %s: %s", pth, err)
+	}
+	return pth
+}
+
+func TestReadManifest(t *testing.T) {
+	manifest, err := ReadManifest(testAPK)
+	if err != nil {
+		t.Fatalf("ReadManifest() error: %s", err)
+	}
+
+	want := Manifest{
+		PackageName:        "com.example.app",
+		VersionCode:        42,
+		VersionName:        "1.2.3",
+		MinSDKVersion:      21,
+		TargetSDKVersion:   30,
+		Permissions:        []string{InternetPermission},
+		Debuggable:         true,
+		LaunchableActivity: "com.example.app.MainActivity",
+	}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Fatalf("ReadManifest() = %+v, want: %+v", manifest, want)
+	}
+	if !manifest.HasPermission(InternetPermission) {
+		t.Errorf("HasPermission(%s) = false", InternetPermission)
+	}
+}
+
+func TestReadManifestWithoutResourceTable(t *testing.T) {
+	pth := writeTestAPK(t, map[string][]byte{"AndroidManifest.xml": readTestZipFile(t, "AndroidManifest.xml")})
+
+	manifest, err := ReadManifest(pth)
+	if err != nil {
+		t.Fatalf("ReadManifest() error: %s", err)
+	}
+	// the reference can not be resolved without the resource table
+	if manifest.PackageName != "com.example.app" || manifest.VersionName != "@0x7f010000" {
+		t.Fatalf("ReadManifest() = %+v", manifest)
+	}
+}
+
+func TestReadManifestMalformed(t *testing.T) {
+	manifest := readTestZipFile(t, "AndroidManifest.xml")
+	table := readTestZipFile(t, "resources.arsc")
+
+	// the string count of the manifest's string pool, which follows the xml header
+	hugeStringCount := append([]byte{}, manifest...)
+	binary.LittleEndian.PutUint32(hugeStringCount[16:], 0xFFFFFFFF)
+
+	// the size of the manifest's xml chunk
+	oversized := append([]byte{}, manifest...)
+	binary.LittleEndian.PutUint32(oversized[4:], uint32(len(manifest)+1))
+
+	for name, test := range map[string]struct {
+		files map[string][]byte
+		want  string
+	}{
+		"no manifest":        {files: map[string][]byte{"resources.arsc": table}, want: "no AndroidManifest.xml"},
+		"truncated manifest": {files: map[string][]byte{"AndroidManifest.xml": manifest[:len(manifest)/2]}, want: "failed to parse AndroidManifest.xml"},
+		"huge string count":  {files: map[string][]byte{"AndroidManifest.xml": hugeStringCount}, want: "string count 4294967295 exceeds the chunk size"},
+		"oversized chunk":    {files: map[string][]byte{"AndroidManifest.xml": oversized}, want: "invalid chunk"},
+		"not a binary xml":   {files: map[string][]byte{"AndroidManifest.xml": []byte("<manifest package=\"com.example.app\"/>")}, want: "failed to parse AndroidManifest.xml"},
+		"truncated table":    {files: map[string][]byte{"AndroidManifest.xml": manifest, "resources.arsc": table[:len(table)/2]}, want: "failed to parse resources.arsc"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadManifest(writeTestAPK(t, test.files))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("ReadManifest() error = %v, want: %s", err, test.want)
+			}
+		})
+	}
+
+	notZip := filepath.Join(t.TempDir(), "app.apk")
+	if err := fileutil.WriteBytesToFile(notZip, manifest); err != nil {
+		t.Fatalf("failed to write %s: %s", notZip, err)
+	}
+	if _, err := ReadManifest(notZip); err == nil {
+		t.Errorf("ReadManifest() succeeded with a file which is not a zip")
+	}
+}
@@ -0,0 +1,210 @@
+package apkinfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Chunk types of the Android binary resource format, see: frameworks/base/libs/androidfw/include/androidfw/ResourceTypes.h
+const (
+	chunkStringPool     = 0x0001
+	chunkTable          = 0x0002
+	chunkXML            = 0x0003
+	chunkXMLStartNS     = 0x0100
+	chunkXMLEndNS       = 0x0101
+	chunkXMLStartTag    = 0x0102
+	chunkXMLEndTag      = 0x0103
+	chunkXMLCData       = 0x0104
+	chunkXMLResourceMap = 0x0180
+	chunkTablePackage   = 0x0200
+	chunkTableType      = 0x0201
+	chunkTableTypeSpec  = 0x0202
+)
+
+// Data types of a typed value.
+const (
+	typeReference = 0x01
+	typeString    = 0x03
+	typeIntDec    = 0x10
+	typeIntHex    = 0x11
+	typeBoolean   = 0x12
+)
+
+const noEntry = 0xFFFFFFFF
+
+var errTruncated = errors.New("truncated chunk")
+
+// chunk is a resource chunk: a header (type, header size, total size) followed by its data.
+type chunk struct {
+	typ        uint16
+	headerSize uint32
+	data       []byte // the whole chunk including its header
+}
+
+func (c chunk) u8(offset int) (uint8, error) {
+	if offset < 0 || offset+1 > len(c.data) {
+		return 0, errTruncated
+	}
+	return c.data[offset], nil
+}
+
+func (c chunk) u16(offset int) (uint16, error) {
+	if offset < 0 || offset+2 > len(c.data) {
+		return 0, errTruncated
+	}
+	return binary.LittleEndian.Uint16(c.data[offset:]), nil
+}
+
+func (c chunk) u32(offset int) (uint32, error) {
+	if offset < 0 || offset+4 > len(c.data) {
+		return 0, errTruncated
+	}
+	return binary.LittleEndian.Uint32(c.data[offset:]), nil
+}
+
+// readChunk reads the chunk starting at the beginning of data.
+func readChunk(data []byte) (chunk, error) {
+	if len(data) < 8 {
+		return chunk{}, errTruncated
+	}
+
+	typ := binary.LittleEndian.Uint16(data)
+	headerSize := uint32(binary.LittleEndian.Uint16(data[2:]))
+	size := binary.LittleEndian.Uint32(data[4:])
+	if size < 8 || headerSize < 8 || headerSize > size || int64(size) > int64(len(data)) {
+		return chunk{}, fmt.Errorf("invalid chunk (type: 0x%04x, header size: %d, size: %d)", typ, headerSize, size)
+	}
+
+	return chunk{typ: typ, headerSize: headerSize, data: data[:size]}, nil
+}
+
+// children returns the chunks following the header of the chunk.
+func (c chunk) children() ([]chunk, error) {
+	children := []chunk{}
+	for offset := int(c.headerSize); offset < len(c.data); {
+		child, err := readChunk(c.data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		offset += len(child.data)
+	}
+	return children, nil
+}
+
+// stringPool ...
+type stringPool []string
+
+func (pool stringPool) get(idx uint32) string {
+	if idx == noEntry || int64(idx) >= int64(len(pool)) {
+		return ""
+	}
+	return pool[idx]
+}
+
+const utf8Flag = 1 << 8
+
+func parseStringPool(c chunk) (stringPool, error) {
+	stringCount, err := c.u32(8)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := c.u32(16)
+	if err != nil {
+		return nil, err
+	}
+	stringsStart, err := c.u32(20)
+	if err != nil {
+		return nil, err
+	}
+
+	// every string has a 4 byte offset following the header, do not trust the count beyond that
+	if int64(stringCount)*4 > int64(len(c.data))-int64(c.headerSize) {
+		return nil, fmt.Errorf("string count %d exceeds the chunk size %d", stringCount, len(c.data))
+	}
+
+	pool := make(stringPool, 0, stringCount)
+	for i := uint32(0); i < stringCount; i++ {
+		offset, err := c.u32(int(c.headerSize) + int(i)*4)
+		if err != nil {
+			return nil, err
+		}
+
+		start := int(stringsStart) + int(offset)
+		var s string
+		if flags&utf8Flag != 0 {
+			s, err = c.utf8String(start)
+		} else {
+			s, err = c.utf16String(start)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read string %d, error: %s", i, err)
+		}
+		pool = append(pool, s)
+	}
+
+	return pool, nil
+}
+
+// utf8String reads a string of an UTF-8 string pool: character count, byte count, bytes.
+func (c chunk) utf8String(offset int) (string, error) {
+	// character count, 1 or 2 bytes
+	n, err := c.u8(offset)
+	if err != nil {
+		return "", err
+	}
+	offset++
+	if n&0x80 != 0 {
+		offset++
+	}
+
+	// byte count, 1 or 2 bytes
+	n, err = c.u8(offset)
+	if err != nil {
+		return "", err
+	}
+	offset++
+	length := int(n)
+	if n&0x80 != 0 {
+		low, err := c.u8(offset)
+		if err != nil {
+			return "", err
+		}
+		offset++
+		length = int(n&0x7f)<<8 | int(low)
+	}
+
+	if offset+length > len(c.data) {
+		return "", errTruncated
+	}
+	return string(c.data[offset : offset+length]), nil
+}
+
+// utf16String reads a string of an UTF-16 string pool: character count, UTF-16 characters.
+func (c chunk) utf16String(offset int) (string, error) {
+	n, err := c.u16(offset)
+	if err != nil {
+		return "", err
+	}
+	offset += 2
+	length := int(n)
+	if n&0x8000 != 0 {
+		low, err := c.u16(offset)
+		if err != nil {
+			return "", err
+		}
+		offset += 2
+		length = int(n&0x7fff)<<16 | int(low)
+	}
+
+	if offset+length*2 > len(c.data) {
+		return "", errTruncated
+	}
+	chars := make([]uint16, length)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(c.data[offset+i*2:])
+	}
+	return string(utf16.Decode(chars)), nil
+}
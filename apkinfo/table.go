@@ -0,0 +1,219 @@
+package apkinfo
+
+import (
+	"fmt"
+)
+
+const (
+	typeFlagSparse   = 0x01
+	typeFlagOffset16 = 0x02
+
+	entryFlagComplex = 0x0001
+	entryFlagCompact = 0x0008
+)
+
+type tableEntry struct {
+	value         value
+	defaultConfig bool
+}
+
+// resourceTable holds the simple (non bag) values of a resources.arsc by resource id.
+type resourceTable struct {
+	entries map[uint32][]tableEntry
+}
+
+// parseResourceTable decodes a resources.arsc.
+func parseResourceTable(data []byte) (*resourceTable, error) {
+	table, err := readChunk(data)
+	if err != nil {
+		return nil, err
+	}
+	if table.typ != chunkTable {
+		return nil, fmt.Errorf("not a resource table, chunk type: 0x%04x", table.typ)
+	}
+
+	children, err := table.children()
+	if err != nil {
+		return nil, err
+	}
+
+	resources := &resourceTable{entries: map[uint32][]tableEntry{}}
+	var globalStrings stringPool
+	for _, c := range children {
+		switch c.typ {
+		case chunkStringPool:
+			if globalStrings, err = parseStringPool(c); err != nil {
+				return nil, fmt.Errorf("failed to parse string pool, error: %s", err)
+			}
+		case chunkTablePackage:
+			if err := resources.parsePackage(c, globalStrings); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return resources, nil
+}
+
+func (resources *resourceTable) parsePackage(c chunk, globalStrings stringPool) error {
+	packageID, err := c.u32(8)
+	if err != nil {
+		return err
+	}
+
+	children, err := c.children()
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		if child.typ != chunkTableType {
+			continue
+		}
+		if err := resources.parseType(child, uint8(packageID), globalStrings); err != nil {
+			return fmt.Errorf("failed to parse resource type, error: %s", err)
+		}
+	}
+	return nil
+}
+
+func (c chunk) isDefaultConfig() bool {
+	size, err := c.u32(20)
+	if err != nil {
+		return false
+	}
+	for offset := 24; offset < 20+int(size) && offset < int(c.headerSize); offset++ {
+		if c.data[offset] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (resources *resourceTable) parseType(c chunk, packageID uint8, globalStrings stringPool) error {
+	typeID, err := c.u8(8)
+	if err != nil {
+		return err
+	}
+	flags, err := c.u8(9)
+	if err != nil {
+		return err
+	}
+	entryCount, err := c.u32(12)
+	if err != nil {
+		return err
+	}
+	entriesStart, err := c.u32(16)
+	if err != nil {
+		return err
+	}
+
+	defaultConfig := c.isDefaultConfig()
+	offsets := int(c.headerSize)
+
+	for i := 0; i < int(entryCount); i++ {
+		var entryIdx uint32
+		var entryOffset uint32
+
+		switch {
+		case flags&typeFlagSparse != 0:
+			idx, err := c.u16(offsets + i*4)
+			if err != nil {
+				return err
+			}
+			offset, err := c.u16(offsets + i*4 + 2)
+			if err != nil {
+				return err
+			}
+			entryIdx, entryOffset = uint32(idx), uint32(offset)*4
+		case flags&typeFlagOffset16 != 0:
+			offset, err := c.u16(offsets + i*2)
+			if err != nil {
+				return err
+			}
+			if offset == 0xFFFF {
+				continue
+			}
+			entryIdx, entryOffset = uint32(i), uint32(offset)*4
+		default:
+			offset, err := c.u32(offsets + i*4)
+			if err != nil {
+				return err
+			}
+			if offset == noEntry {
+				continue
+			}
+			entryIdx, entryOffset = uint32(i), offset
+		}
+
+		v, ok, err := c.entryValue(int(entriesStart) + int(entryOffset))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if v.dataType == typeString {
+			v.raw = globalStrings.get(v.data)
+		}
+
+		id := uint32(packageID)<<24 | uint32(typeID)<<16 | entryIdx
+		resources.entries[id] = append(resources.entries[id], tableEntry{value: v, defaultConfig: defaultConfig})
+	}
+
+	return nil
+}
+
+// entryValue reads the value of a simple entry, bag (complex) entries are skipped.
+func (c chunk) entryValue(offset int) (value, bool, error) {
+	flags, err := c.u16(offset + 2)
+	if err != nil {
+		return value{}, false, err
+	}
+
+	if flags&entryFlagCompact != 0 {
+		data, err := c.u32(offset + 4)
+		if err != nil {
+			return value{}, false, err
+		}
+		return value{dataType: uint8(flags >> 8), data: data}, true, nil
+	}
+
+	if flags&entryFlagComplex != 0 {
+		return value{}, false, nil
+	}
+
+	size, err := c.u16(offset)
+	if err != nil {
+		return value{}, false, err
+	}
+	valueOffset := offset + int(size)
+
+	dataType, err := c.u8(valueOffset + 3)
+	if err != nil {
+		return value{}, false, err
+	}
+	data, err := c.u32(valueOffset + 4)
+	if err != nil {
+		return value{}, false, err
+	}
+	return value{dataType: dataType, data: data}, true, nil
+}
+
+// lookup returns the value of the resource, the default configuration is preferred.
+func (resources *resourceTable) lookup(id uint32) (value, bool) {
+	if resources == nil {
+		return value{}, false
+	}
+
+	entries := resources.entries[id]
+	for _, entry := range entries {
+		if entry.defaultConfig {
+			return entry.value, true
+		}
+	}
+	if len(entries) > 0 {
+		return entries[0].value, true
+	}
+	return value{}, false
+}
@@ -0,0 +1,196 @@
+package apkinfo
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Resource ids of the android namespace attributes, used if the attribute names are stripped by an obfuscator.
+const (
+	attrName             = 0x01010003
+	attrDebuggable       = 0x0101000f
+	attrVersionCode      = 0x0101021b
+	attrVersionName      = 0x0101021c
+	attrMinSdkVersion    = 0x0101020c
+	attrTargetSdkVersion = 0x01010270
+)
+
+var attrNamesByID = map[uint32]string{
+	attrName:             "name",
+	attrDebuggable:       "debuggable",
+	attrVersionCode:      "versionCode",
+	attrVersionName:      "versionName",
+	attrMinSdkVersion:    "minSdkVersion",
+	attrTargetSdkVersion: "targetSdkVersion",
+}
+
+// value is a typed resource value.
+type value struct {
+	dataType uint8
+	data     uint32
+	raw      string
+}
+
+type xmlAttr struct {
+	namespace string
+	name      string
+	value     value
+}
+
+type xmlElement struct {
+	name     string
+	attrs    []xmlAttr
+	children []*xmlElement
+}
+
+// attr returns the value of the attribute with the given name, regardless of its namespace.
+func (element xmlElement) attr(name string) (value, bool) {
+	for _, attr := range element.attrs {
+		if attr.name == name {
+			return attr.value, true
+		}
+	}
+	return value{}, false
+}
+
+// childrenNamed ...
+func (element xmlElement) childrenNamed(name string) []*xmlElement {
+	children := []*xmlElement{}
+	for _, child := range element.children {
+		if child.name == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// parseXML decodes an Android binary xml document and returns its root element.
+func parseXML(data []byte) (*xmlElement, error) {
+	document, err := readChunk(data)
+	if err != nil {
+		return nil, err
+	}
+	if document.typ != chunkXML {
+		return nil, fmt.Errorf("not a binary xml, chunk type: 0x%04x", document.typ)
+	}
+
+	children, err := document.children()
+	if err != nil {
+		return nil, err
+	}
+
+	var pool stringPool
+	var resourceMap []uint32
+	var root *xmlElement
+	stack := []*xmlElement{}
+
+	for _, c := range children {
+		switch c.typ {
+		case chunkStringPool:
+			if pool, err = parseStringPool(c); err != nil {
+				return nil, fmt.Errorf("failed to parse string pool, error: %s", err)
+			}
+		case chunkXMLResourceMap:
+			for offset := int(c.headerSize); offset+4 <= len(c.data); offset += 4 {
+				id, err := c.u32(offset)
+				if err != nil {
+					return nil, err
+				}
+				resourceMap = append(resourceMap, id)
+			}
+		case chunkXMLStartTag:
+			element, err := parseStartTag(c, pool, resourceMap)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, element)
+			} else if root == nil {
+				root = element
+			}
+			stack = append(stack, element)
+		case chunkXMLEndTag:
+			if len(stack) == 0 {
+				return nil, errors.New("unbalanced end tag")
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("no root element")
+	}
+	return root, nil
+}
+
+func parseStartTag(c chunk, pool stringPool, resourceMap []uint32) (*xmlElement, error) {
+	ext := int(c.headerSize)
+
+	nameIdx, err := c.u32(ext + 4)
+	if err != nil {
+		return nil, err
+	}
+	attributeStart, err := c.u16(ext + 8)
+	if err != nil {
+		return nil, err
+	}
+	attributeSize, err := c.u16(ext + 10)
+	if err != nil {
+		return nil, err
+	}
+	attributeCount, err := c.u16(ext + 12)
+	if err != nil {
+		return nil, err
+	}
+
+	element := &xmlElement{name: pool.get(nameIdx)}
+	for i := 0; i < int(attributeCount); i++ {
+		offset := ext + int(attributeStart) + i*int(attributeSize)
+
+		nsIdx, err := c.u32(offset)
+		if err != nil {
+			return nil, err
+		}
+		attrNameIdx, err := c.u32(offset + 4)
+		if err != nil {
+			return nil, err
+		}
+		rawIdx, err := c.u32(offset + 8)
+		if err != nil {
+			return nil, err
+		}
+		dataType, err := c.u8(offset + 15)
+		if err != nil {
+			return nil, err
+		}
+		data, err := c.u32(offset + 16)
+		if err != nil {
+			return nil, err
+		}
+
+		name := pool.get(attrNameIdx)
+		if int64(attrNameIdx) < int64(len(resourceMap)) {
+			if knownName, ok := attrNamesByID[resourceMap[attrNameIdx]]; ok {
+				name = knownName
+			}
+		}
+
+		element.attrs = append(element.attrs, xmlAttr{
+			namespace: pool.get(nsIdx),
+			name:      name,
+			value: value{
+				dataType: dataType,
+				data:     data,
+				raw:      pool.get(rawIdx),
+			},
+		})
+
+		if dataType == typeString {
+			element.attrs[len(element.attrs)-1].value.raw = pool.get(data)
+		}
+	}
+
+	return element, nil
+}
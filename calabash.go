@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/gems"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+)
+
+// calabashAndroid creates calabash-android commands with the gem version determined by the step.
+type calabashAndroid struct {
+	version     string
+	useBundler  bool
+	gemFilePath string
+	workDir     string
+}
+
+// command returns a calabash-android command with the given subcommand and arguments, run in the work dir.
+func (calabash calabashAndroid) command(envs []string, args ...string) (*command.Model, error) {
+	cmdEnvs := []string{}
+
+	cmdArgs := []string{"calabash-android"}
+	if calabash.version != "" {
+		cmdArgs = append(cmdArgs, fmt.Sprintf("_%s_", calabash.version))
+	} else if calabash.useBundler {
+		cmdArgs = append([]string{"bundle", "exec"}, cmdArgs...)
+		cmdEnvs = append(cmdEnvs, "BUNDLE_GEMFILE="+calabash.gemFilePath)
+	}
+	cmdArgs = append(cmdArgs, args...)
+
+	cmd, err := cmdExecutor.NewRubyCommand(cmdArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.AppendEnvs(append(cmdEnvs, envs...)...)
+	cmd.SetDir(calabash.workDir)
+
+	return cmd, nil
+}
+
+const calabashAndroidGemName = "calabash-android"
+
+// gemListVersions returns the installed, or if remote is true the available, versions of the calabash-android gem.
+func gemListVersions(remote bool) ([]string, error) {
+	args := []string{"gem", "list", "^" + calabashAndroidGemName + "$"}
+	if remote {
+		args = append(args, "--remote", "--all")
+	}
+
+	cmd, err := cmdExecutor.NewRubyCommand(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	logCommand(cmd)
+
+	out, err := cmdExecutor.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+	return gems.VersionsFromGemList(out, calabashAndroidGemName), nil
+}
+
+// resolveCalabashAndroidVersion returns the highest installed calabash-android version matching the constraint,
+// or the highest available one if no installed version matches.
+func resolveCalabashAndroidVersion(constraint string) (string, error) {
+	constraints, err := gems.NewConstraints(constraint)
+	if err != nil {
+		return "", fmt.Errorf("invalid calabash-android version constraint (%s), error: %s", constraint, err)
+	}
+
+	installed, err := gemListVersions(false)
+	if err != nil {
+		return "", err
+	}
+	if resolved, found := gems.HighestMatching(installed, constraints); found {
+		logger.Printf("installed calabash-android version matching %s: %s", constraint, resolved)
+		return resolved, nil
+	}
+
+	logger.Warnf("no installed calabash-android version matches: %s", constraint)
+
+	available, err := gemListVersions(true)
+	if err != nil {
+		return "", err
+	}
+	if resolved, found := gems.HighestMatching(available, constraints); found {
+		logger.Printf("available calabash-android version matching %s: %s", constraint, resolved)
+		return resolved, nil
+	}
+
+	return "", fmt.Errorf("no calabash-android version matches: %s", constraint)
+}
+
+// isCalabashAndroidInstalled returns whether the given calabash-android version is installed.
+func isCalabashAndroidInstalled(version string) (bool, error) {
+	installed, err := gemListVersions(false)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range installed {
+		if v == version {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// latestInstalledCalabashAndroidVersion returns the highest installed calabash-android version.
+func latestInstalledCalabashAndroidVersion() (string, error) {
+	installed, err := gemListVersions(false)
+	if err != nil {
+		return "", err
+	}
+	return gems.Highest(installed)
+}
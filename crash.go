@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logcat"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+)
+
+// appCrash is a crash or ANR of the app found in the logcat of a device.
+type appCrash struct {
+	logcat.Crash
+	device string
+	// scenario is the scenario which was possibly running at the time of the crash, empty if unknown.
+	// It is an estimate, the report has no timestamps.
+	scenario string
+}
+
+func (crash appCrash) String() string {
+	s := fmt.Sprintf("%s of %s (pid %d) on %s at %s", crash.Kind, crash.Process, crash.PID, crash.device, crash.Time)
+	if crash.scenario != "" {
+		s += ", possibly during scenario: " + crash.scenario
+	}
+	if crash.Message != "" {
+		s += ": " + crash.Message
+	}
+	return s
+}
+
+// scenarioAt estimates the scenario which was running at the elapsed time of the run.
+// The report has no timestamps, the start of the scenarios are estimated by summing the durations,
+// which underestimates them (the app is reinstalled and restarted between the scenarios).
+// So the crashed scenario is one of the scenarios estimated to start before the crash,
+// the last failed one is picked, as the crash most likely failed the scenario.
+func scenarioAt(features []cucumber.Feature, elapsed time.Duration) (cucumber.Scenario, bool) {
+	var start time.Duration
+	var running, failed *cucumber.Scenario
+
+	for _, feature := range features {
+		for _, scenario := range feature.Scenarios() {
+			if start > elapsed {
+				break
+			}
+
+			scenario := scenario
+			running = &scenario
+			if scenario.Status() == cucumber.StatusFailed {
+				failed = &scenario
+			}
+			start += scenario.Duration()
+		}
+	}
+
+	if failed != nil {
+		return *failed, true
+	}
+	if running != nil {
+		return *running, true
+	}
+	return cucumber.Scenario{}, false
+}
+
+// detectCrashes scans the captured logcat for the crashes of the app, and estimates the scenarios they happened during.
+// No crash is detected if the package name is unknown, the crashes of other processes must not fail the step.
+func detectCrashes(target logcatTarget, packageName string) ([]appCrash, error) {
+	if packageName == "" {
+		return []appCrash{}, nil
+	}
+
+	lines, err := logcat.ParseFile(target.pth)
+	if err != nil {
+		return nil, err
+	}
+
+	crashes := []appCrash{}
+	found := logcat.FindCrashes(lines, packageName)
+	if len(found) == 0 {
+		return crashes, nil
+	}
+
+	// the logcat was cleared right before the run, the first line is about the start of the run
+	features, err := cucumber.ParseReportFile(target.reportPth)
+	if err != nil {
+		logger.Warnf("Failed to parse cucumber json report (%s), crashes are not correlated with the scenarios, error: %s", target.reportPth, err)
+	}
+
+	for _, crash := range found {
+		c := appCrash{Crash: crash, device: target.serial}
+		if elapsed, err := logcat.Elapsed(lines[0].Time, crash.Time); err == nil && features != nil {
+			if scenario, ok := scenarioAt(features, elapsed); ok {
+				c.scenario = fmt.Sprintf("%s (%s)", scenario.Name, scenario.Location())
+			}
+		}
+		crashes = append(crashes, c)
+	}
+	return crashes, nil
+}
+
+// reportCrashes prints and exports the crashes of the app, it returns an error if the app crashed and fail is set.
+func reportCrashes(crashes []appCrash, fail bool) error {
+	summaries := []string{}
+	for _, crash := range crashes {
+		summaries = append(summaries, crash.String())
+	}
+
+	exportOutputs([][]string{
+		{"BITRISE_CALABASH_ANDROID_CRASH_COUNT", strconv.Itoa(len(crashes))},
+		{"BITRISE_CALABASH_ANDROID_CRASHES", strings.Join(summaries, "\n")},
+	})
+
+	if len(crashes) == 0 {
+		return nil
+	}
+
+	logf := logger.Warnf
+	if fail {
+		logf = logger.Errorf
+	}
+
+	logger.Println()
+	logf("The app crashed %d times during the run:", len(crashes))
+	for _, crash := range crashes {
+		logger.Println()
+		logf("%s", crash)
+		for _, line := range crash.StackTrace {
+			logger.Printf("    %s", line)
+		}
+	}
+
+	if !fail {
+		return nil
+	}
+	return fmt.Errorf("the app crashed %d times during the run", len(crashes))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testCrashLogcat = `--------- beginning of main
+10-17 04:15:28.000   500   520 I ActivityManager: Start proc 1234:com.example.app/u0a76 for activity {com.example.app/.MainActivity}
+10-17 04:15:30.500  1234  1234 E AndroidRuntime: FATAL EXCEPTION: main
+10-17 04:15:30.500  1234  1234 E AndroidRuntime: Process: com.example.app, PID: 1234
+10-17 04:15:30.500  1234  1234 E AndroidRuntime: java.lang.NullPointerException: user is null
+10-17 04:15:30.500  1234  1234 E AndroidRuntime: 	at com.example.app.LoginActivity.onError(LoginActivity.java:42)
+10-17 04:15:30.500  1234  1234 E AndroidRuntime: 	at android.os.Handler.dispatchMessage(Handler.java:106)
+10-17 04:15:30.600  2000  2000 E AndroidRuntime: FATAL EXCEPTION: main
+10-17 04:15:30.600  2000  2000 E AndroidRuntime: Process: com.other.app, PID: 2000
+10-17 04:15:30.600  2000  2000 E AndroidRuntime: java.lang.IllegalStateException
+10-17 04:15:31.000  3000  3000 F DEBUG   : *** *** *** *** *** *** *** *** *** *** *** *** *** *** *** ***
+10-17 04:15:31.000  3000  3000 F DEBUG   : pid: 1240, tid: 1241, name: RenderThread  >>> com.example.app <<<
+10-17 04:15:31.000  3000  3000 F DEBUG   : signal 11 (SIGSEGV), code 1 (SEGV_MAPERR), fault addr 0x0
+10-17 04:15:31.000  3000  3000 F DEBUG   : backtrace:
+10-17 04:15:31.000  3000  3000 F DEBUG   :     #00 pc 0001a2b4  /data/app/com.example.app/lib/arm64/libnative.so (crash+20)
+10-17 04:15:32.000   500   520 E ActivityManager: ANR in com.example.app (com.example.app/.MainActivity)
+10-17 04:15:32.000   500   520 E ActivityManager: PID: 1250
+10-17 04:15:32.000   500   520 E ActivityManager: Reason: Input dispatching timed out
+10-17 04:15:32.000   500   520 E ActivityManager: CPU usage from 0ms to 5000ms later:
+`
+
+func TestDetectCrashes(t *testing.T) {
+	fake := withFakeExecutor(t)
+
+	dir := t.TempDir()
+	target := logcatTarget{
+		serial:    "emulator-5554",
+		pth:       filepath.Join(dir, "logcat.txt"),
+		reportPth: filepath.Join(dir, "calabash-android_report.json"),
+	}
+	writeTestFile(t, target.pth, testCrashLogcat)
+	writeTestFile(t, target.reportPth, testCucumberReport)
+
+	crashes, err := detectCrashes(target, "com.example.app")
+	if err != nil {
+		t.Fatalf("detectCrashes() error: %s", err)
+	}
+
+	want := []string{
+		"crash of com.example.app (pid 1234) on emulator-5554 at 10-17 04:15:30.500, possibly during scenario: Invalid login (features/login.feature:8): java.lang.NullPointerException: user is null",
+		"native crash of com.example.app (pid 1240) on emulator-5554 at 10-17 04:15:31.000, possibly during scenario: Invalid login (features/login.feature:8): signal 11 (SIGSEGV), code 1 (SEGV_MAPERR), fault addr 0x0",
+		"ANR of com.example.app (pid 1250) on emulator-5554 at 10-17 04:15:32.000, possibly during scenario: Invalid login (features/login.feature:8): Input dispatching timed out",
+	}
+	got := []string{}
+	for _, crash := range crashes {
+		got = append(got, crash.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("crashes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if trace := crashes[0].StackTrace; len(trace) != 2 || trace[0] != "at com.example.app.LoginActivity.onError(LoginActivity.java:42)" {
+		t.Errorf("stack trace = %v", trace)
+	}
+	if trace := crashes[1].StackTrace; len(trace) != 1 || !strings.HasPrefix(trace[0], "#00 pc 0001a2b4") {
+		t.Errorf("native backtrace = %v", trace)
+	}
+
+	if err := reportCrashes(crashes, false); err != nil {
+		t.Errorf("reportCrashes() error: %s, want the crashes reported only", err)
+	}
+	if err := reportCrashes(crashes, true); err == nil {
+		t.Errorf("reportCrashes() succeeded, want the crashes to fail the run")
+	}
+	if count := fake.exports()["BITRISE_CALABASH_ANDROID_CRASH_COUNT"]; count != "3" {
+		t.Errorf("crash count = %s, want: 3", count)
+	}
+}
+
+func TestDetectCrashesWithoutPackageName(t *testing.T) {
+	withFakeExecutor(t)
+
+	dir := t.TempDir()
+	target := logcatTarget{
+		serial:    "emulator-5554",
+		pth:       filepath.Join(dir, "logcat.txt"),
+		reportPth: filepath.Join(dir, "calabash-android_report.json"),
+	}
+	writeTestFile(t, target.pth, testCrashLogcat)
+	writeTestFile(t, target.reportPth, testCucumberReport)
+
+	// the crashes of other processes, like com.other.app, must not fail the step
+	crashes, err := detectCrashes(target, "")
+	if err != nil {
+		t.Fatalf("detectCrashes() error: %s", err)
+	}
+	if len(crashes) != 0 {
+		t.Fatalf("crashes = %v, want none if the package name is unknown", crashes)
+	}
+}
@@ -0,0 +1,173 @@
+package cucumber
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// HTMLFailure is a failed scenario of the html report.
+type HTMLFailure struct {
+	Feature  string
+	Scenario string
+	// Location is the file:line of the scenario
+	Location string
+	// Step is the failed step with its keyword, or the failed example row of a scenario outline,
+	// empty if a hook failed
+	Step string
+	// StepLocation is the file:line of the step definition, empty for an example row
+	StepLocation string
+	Message      string
+}
+
+// voidElements have no end tag.
+var voidElements = map[string]bool{
+	"br": true, "hr": true, "img": true, "input": true, "meta": true, "link": true, "col": true, "wbr": true,
+}
+
+func hasClass(token html.Token, class string) bool {
+	for _, attr := range token.Attr {
+		if attr.Key != "class" {
+			continue
+		}
+		for _, c := range strings.Fields(attr.Val) {
+			if c == class {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasAttr(token html.Token, key string) bool {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// innerText reads the tokens of the current element up to its end tag, and returns its text with the entities decoded.
+func innerText(z *html.Tokenizer) string {
+	var text strings.Builder
+	for depth := 1; depth > 0; {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(text.String())
+		case html.StartTagToken:
+			if name, _ := z.TagName(); !voidElements[string(name)] {
+				depth++
+			}
+		case html.EndTagToken:
+			depth--
+		case html.TextToken:
+			text.WriteString(html.UnescapeString(string(z.Raw())))
+		}
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// withoutKeyword strips the keyword (like `Feature:` or `Scenario:`) from the title of a feature element.
+func withoutKeyword(title string) string {
+	keyword, name := title, ""
+	if i := strings.Index(title, ":"); i != -1 {
+		keyword, name = title[:i], strings.TrimSpace(title[i+1:])
+	}
+	if name == "" {
+		return keyword
+	}
+	return name
+}
+
+// ParseHTMLReport returns the failed scenarios of the report of cucumber's html formatter.
+// A scenario outline has a failure for every failed example row.
+func ParseHTMLReport(reader io.Reader) ([]HTMLFailure, error) {
+	failures := []HTMLFailure{}
+
+	var feature string
+	var scenario HTMLFailure
+	// row holds the cells of the last example row, newRow is set until the first cell of a row
+	var row []string
+	newRow := false
+	// stepFailed is false if the current step passed, or is pending, its message is not a failure
+	stepFailed := true
+	reported := false
+
+	z := html.NewTokenizer(reader)
+	for {
+		tokenType := z.Next()
+		if tokenType == html.ErrorToken {
+			if z.Err() == io.EOF {
+				return failures, nil
+			}
+			return nil, z.Err()
+		}
+		if tokenType != html.StartTagToken {
+			continue
+		}
+
+		token := z.Token()
+		switch {
+		case token.Data == "div" && hasClass(token, "feature"):
+			feature = ""
+		case token.Data == "h2":
+			feature = withoutKeyword(innerText(z))
+		case token.Data == "div" && (hasClass(token, "scenario") || hasClass(token, "background")):
+			scenario = HTMLFailure{Feature: feature}
+			row = nil
+			stepFailed, reported = true, false
+		case token.Data == "span" && hasClass(token, "scenario_file"):
+			scenario.Location = innerText(z)
+		case token.Data == "h3":
+			scenario.Scenario = withoutKeyword(innerText(z))
+		case token.Data == "li" && hasClass(token, "step"):
+			row = nil
+			stepFailed = hasClass(token, "failed")
+		case token.Data == "div" && hasClass(token, "step_name"):
+			scenario.Step = innerText(z)
+		case token.Data == "div" && hasClass(token, "step_file"):
+			scenario.StepLocation = innerText(z)
+		case token.Data == "tr":
+			newRow = true
+			stepFailed, reported = true, false
+		case (token.Data == "div" && hasClass(token, "message")) || (token.Data == "td" && hasAttr(token, "colspan")):
+			// the message of a failed example row is in a row of its own, spanning the columns
+			message := innerText(z)
+			if !stepFailed || reported || message == "" {
+				continue
+			}
+
+			failure := scenario
+			failure.Message = message
+			if len(row) > 0 {
+				failure.Step = "| " + strings.Join(row, " | ") + " |"
+				failure.StepLocation = ""
+			}
+			failures = append(failures, failure)
+			reported = true
+		case token.Data == "td":
+			if newRow {
+				row, newRow = nil, false
+			}
+			row = append(row, innerText(z))
+		}
+	}
+}
+
+// ParseHTMLReportFile returns the failed scenarios of the html report at the given path.
+func ParseHTMLReportFile(pth string) (failures []HTMLFailure, err error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	return ParseHTMLReport(file)
+}
@@ -0,0 +1,60 @@
+package cucumber
+
+import (
+	"strings"
+	"testing"
+)
+
+const testHTMLReport = `<html><head><script type="text/javascript">if (a < b && c > d) { moveProgressBar('100%'); }</script></head><body>
+<div class="cucumber"><h1>Cucumber Features</h1>
+<div class="feature"><h2><span class="val">Feature: Login</span></h2>
+<div class='scenario'><span class="scenario_file">features/login.feature:3</span><h3 id="scenario_1"><span class="keyword">Scenario:</span> <span class="val">Valid login</span></h3><ol>
+<li id='' class='step passed'><div class="step_name"><span class="keyword">Given </span><span class="step val">I log in</span></div><div class="step_file"><span>features/step_definitions/login_steps.rb:1</span></div></li>
+<li id='' class='step pending'><div class="step_name"><span class="keyword">And </span><span class="step val">I wait</span></div><div class="step_file"><span>features/step_definitions/login_steps.rb:9</span></div><div class="message"><pre>TODO (Cucumber::Pending)</pre></div></li>
+</ol></div>
+<div class='scenario'><span class="scenario_file">features/login.feature:8</span><h3 id="scenario_2"><span class="keyword">Scenario:</span> <span class="val">Invalid login</span></h3><ol>
+<li id='' class='step failed'><div class="step_name"><span class="keyword">Then </span><span class="step val">I see &quot;<span class="param">Wrong password</span>&quot;</span></div><div class="step_file"><span>features/step_definitions/login_steps.rb:5</span></div><div class="message"><pre>Expected &lt;Wrong password&gt; &amp; got nothing (RuntimeError)
+./features/step_definitions/login_steps.rb:6</pre></div><div class="backtrace"><pre>login_steps.rb:6</pre></div></li>
+</ol></div>
+<div class='scenario outline'><span class="scenario_file">features/login.feature:12</span><h3 id="scenario_3"><span class="keyword">Scenario Outline:</span> <span class="val">Login as</span></h3><ol>
+<li class='step skipped'><div class="step_name"><span class="keyword">Given </span><span class="step val">I log in as &lt;user&gt;</span></div><div class="step_file"><span>features/step_definitions/login_steps.rb:1</span></div></li>
+</ol><div class="examples"><table>
+<tr class="step" id="row_15"><td class="step" id="row_15_0"><div><span class="step param">user</span></div></td></tr>
+<tr class="step" id="row_16"><td class="step" id="row_16_0"><div><span class="step param">admin</span></div></td></tr>
+<tr class="step" id="row_17"><td class="step" id="row_17_0"><div><span class="step param">guest</span></div></td></tr>
+<tr><td colspan="1" class="failed"><div class="message"><pre>guest is not allowed</pre></div></td></tr>
+</table></div></div>
+</div></div></body></html>`
+
+func TestParseHTMLReport(t *testing.T) {
+	failures, err := ParseHTMLReport(strings.NewReader(testHTMLReport))
+	if err != nil {
+		t.Fatalf("ParseHTMLReport() error: %s", err)
+	}
+
+	want := []HTMLFailure{
+		{
+			Feature:      "Login",
+			Scenario:     "Invalid login",
+			Location:     "features/login.feature:8",
+			Step:         `Then I see "Wrong password"`,
+			StepLocation: "features/step_definitions/login_steps.rb:5",
+			Message:      "Expected <Wrong password> & got nothing (RuntimeError)\n./features/step_definitions/login_steps.rb:6",
+		},
+		{
+			Feature:  "Login",
+			Scenario: "Login as",
+			Location: "features/login.feature:12",
+			Step:     "| guest |",
+			Message:  "guest is not allowed",
+		},
+	}
+	if len(failures) != len(want) {
+		t.Fatalf("failures = %+v, want: %+v", failures, want)
+	}
+	for i := range want {
+		if failures[i] != want[i] {
+			t.Errorf("failure %d = %+v, want: %+v", i, failures[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package cucumber
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Option is a command line option of cucumber, or a feature path if Name is empty.
+type Option struct {
+	// Name is the long name of the option, like --format
+	Name  string
+	Value string
+}
+
+// Report is a report written by a cucumber formatter.
+type Report struct {
+	Format string
+	// Path is the --out path of the formatter, empty if the report is written to the stdout
+	Path string
+}
+
+// Options are the parsed command line options of cucumber.
+type Options []Option
+
+// valueOptions maps the cucumber options followed by a value to their long names.
+var valueOptions = map[string]string{
+	"-r": "--require", "--require": "--require",
+	"-f": "--format", "--format": "--format",
+	"-o": "--out", "--out": "--out",
+	"-t": "--tags", "--tags": "--tags",
+	"-n": "--name", "--name": "--name",
+	"-e": "--exclude", "--exclude": "--exclude",
+	"-p": "--profile", "--profile": "--profile",
+	"-l": "--lines", "--lines": "--lines",
+	"-I": "--snippet-type", "--snippet-type": "--snippet-type",
+	"-j": "--jars", "--jars": "--jars",
+	"--i18n": "--i18n", "--i18n-keywords": "--i18n-keywords",
+	"--order": "--order", "--retry": "--retry",
+}
+
+// pathValueOptions are the options, whose value can not look like an option.
+// The values of the others (like the tag expression `~@wip`) are taken as they are.
+var pathValueOptions = map[string]bool{
+	"--require": true, "--format": true, "--out": true, "--profile": true, "--jars": true,
+}
+
+// splitOption splits the value from the option given as --name=value or -nvalue.
+func splitOption(arg string) (string, string, bool) {
+	if strings.HasPrefix(arg, "--") {
+		if i := strings.Index(arg, "="); i != -1 {
+			if name, ok := valueOptions[arg[:i]]; ok {
+				return name, arg[i+1:], true
+			}
+		}
+		return "", "", false
+	}
+	if strings.HasPrefix(arg, "-") && len(arg) > 2 {
+		if name, ok := valueOptions[arg[:2]]; ok {
+			return name, arg[2:], true
+		}
+	}
+	return "", "", false
+}
+
+// ParseOptions parses the command line options of cucumber.
+// It returns an error if a value is missing, or if the formatters and --out paths do not pair up.
+func ParseOptions(args []string) (Options, error) {
+	options := Options{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if name, value, ok := splitOption(arg); ok {
+			if value == "" {
+				return nil, fmt.Errorf("missing value of %s", name)
+			}
+			options = append(options, Option{Name: name, Value: value})
+			continue
+		}
+
+		name, ok := valueOptions[arg]
+		if !ok {
+			if strings.HasPrefix(arg, "-") {
+				options = append(options, Option{Name: arg})
+			} else {
+				options = append(options, Option{Value: arg})
+			}
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("missing value of %s", arg)
+		}
+		value := args[i+1]
+		if value == "" || (pathValueOptions[name] && strings.HasPrefix(value, "-")) {
+			return nil, fmt.Errorf("missing value of %s, got: %q", arg, value)
+		}
+		options = append(options, Option{Name: name, Value: value})
+		i++
+	}
+
+	if _, err := options.reports(); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// reports pairs the formatters with their --out paths, like cucumber does:
+// an --out applies to the preceding --format, an --out without a --format applies to the default pretty formatter.
+func (options Options) reports() ([]Report, error) {
+	reports := []Report{}
+	out := false
+	for _, option := range options {
+		switch option.Name {
+		case "--format":
+			reports = append(reports, Report{Format: option.Value})
+			out = false
+		case "--out":
+			if len(reports) == 0 {
+				reports = append(reports, Report{Format: "pretty"})
+			} else if out {
+				return nil, fmt.Errorf("--out %s does not follow a --format, the previous --out already belongs to the --format %s", option.Value, reports[len(reports)-1].Format)
+			}
+			reports[len(reports)-1].Path = option.Value
+			out = true
+		}
+	}
+
+	paths := map[string]string{}
+	stdoutFormat := ""
+	for _, report := range reports {
+		if report.Path == "" {
+			if stdoutFormat != "" {
+				return nil, fmt.Errorf("both --format %s and --format %s write to the stdout, add an --out to one of them", stdoutFormat, report.Format)
+			}
+			stdoutFormat = report.Format
+			continue
+		}
+		if format, ok := paths[report.Path]; ok {
+			return nil, fmt.Errorf("both --format %s and --format %s write to --out %s", format, report.Format, report.Path)
+		}
+		paths[report.Path] = report.Format
+	}
+
+	return reports, nil
+}
+
+// Reports returns the reports written by the formatters, the options are validated by ParseOptions.
+func (options Options) Reports() []Report {
+	reports, _ := options.reports()
+	return reports
+}
+
+func (options Options) values(name string) []string {
+	values := []string{}
+	for _, option := range options {
+		if option.Name == name {
+			values = append(values, option.Value)
+		}
+	}
+	return values
+}
+
+// Profiles returns the --profile names.
+func (options Options) Profiles() []string {
+	return options.values("--profile")
+}
+
+// Tags returns the --tags expressions.
+func (options Options) Tags() []string {
+	return options.values("--tags")
+}
+
+// Requires returns the --require paths.
+func (options Options) Requires() []string {
+	return options.values("--require")
+}
+
+// FeaturePaths returns the feature paths (positional arguments).
+func (options Options) FeaturePaths() []string {
+	return options.values("")
+}
+
+// HasFormat reports whether a formatter is set, which disables the default pretty formatter of cucumber.
+func (options Options) HasFormat() bool {
+	return len(options.values("--format")) > 0
+}
+
+// WithoutFeaturePaths returns the options without the feature paths.
+func (options Options) WithoutFeaturePaths() Options {
+	filtered := Options{}
+	for _, option := range options {
+		if option.Name != "" {
+			filtered = append(filtered, option)
+		}
+	}
+	return filtered
+}
+
+// WithOutputs returns the options with every --out path replaced by the result of fn.
+func (options Options) WithOutputs(fn func(string) string) Options {
+	replaced := Options{}
+	for _, option := range options {
+		if option.Name == "--out" {
+			option.Value = fn(option.Value)
+		}
+		replaced = append(replaced, option)
+	}
+	return replaced
+}
+
+// Args returns the command line arguments of the options, the options are given by their long names.
+func (options Options) Args() []string {
+	args := []string{}
+	for _, option := range options {
+		if option.Name != "" {
+			args = append(args, option.Name)
+		}
+		if option.Value != "" {
+			args = append(args, option.Value)
+		}
+	}
+	return args
+}
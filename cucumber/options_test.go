@@ -0,0 +1,106 @@
+package cucumber
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	options, err := ParseOptions([]string{
+		"-p", "ci", "--tags", "~@wip", "-r", "features", "--strict",
+		"-f", "html", "-o", "reports/report.html",
+		"--format=junit", "--out=/tmp/junit",
+		"-fpretty",
+		"features/login.feature",
+	})
+	if err != nil {
+		t.Fatalf("ParseOptions() error: %s", err)
+	}
+
+	if profiles := options.Profiles(); !reflect.DeepEqual(profiles, []string{"ci"}) {
+		t.Errorf("Profiles() = %v", profiles)
+	}
+	if tags := options.Tags(); !reflect.DeepEqual(tags, []string{"~@wip"}) {
+		t.Errorf("Tags() = %v", tags)
+	}
+	if requires := options.Requires(); !reflect.DeepEqual(requires, []string{"features"}) {
+		t.Errorf("Requires() = %v", requires)
+	}
+	if paths := options.FeaturePaths(); !reflect.DeepEqual(paths, []string{"features/login.feature"}) {
+		t.Errorf("FeaturePaths() = %v", paths)
+	}
+	if !options.HasFormat() {
+		t.Errorf("HasFormat() = false")
+	}
+
+	wantReports := []Report{{Format: "html", Path: "reports/report.html"}, {Format: "junit", Path: "/tmp/junit"}, {Format: "pretty"}}
+	if reports := options.Reports(); !reflect.DeepEqual(reports, wantReports) {
+		t.Errorf("Reports() = %v, want: %v", reports, wantReports)
+	}
+
+	// the options are given by their long names
+	wantArgs := "--profile ci --tags ~@wip --require features --strict --format html --out reports/report.html --format junit --out /tmp/junit --format pretty features/login.feature"
+	if args := strings.Join(options.Args(), " "); args != wantArgs {
+		t.Errorf("Args() = %s, want: %s", args, wantArgs)
+	}
+
+	withoutPaths := options.WithoutFeaturePaths().WithOutputs(func(pth string) string { return pth + ".1" })
+	if paths := withoutPaths.FeaturePaths(); len(paths) != 0 {
+		t.Errorf("WithoutFeaturePaths() kept %v", paths)
+	}
+	wantReports = []Report{{Format: "html", Path: "reports/report.html.1"}, {Format: "junit", Path: "/tmp/junit.1"}, {Format: "pretty"}}
+	if reports := withoutPaths.Reports(); !reflect.DeepEqual(reports, wantReports) {
+		t.Errorf("WithOutputs() reports = %v, want: %v", reports, wantReports)
+	}
+}
+
+func TestParseOptionsDefaultFormatter(t *testing.T) {
+	// an --out without a --format belongs to the default pretty formatter
+	options, err := ParseOptions([]string{"--out", "pretty.txt", "--tags", "@smoke"})
+	if err != nil {
+		t.Fatalf("ParseOptions() error: %s", err)
+	}
+	if options.HasFormat() {
+		t.Errorf("HasFormat() = true without --format")
+	}
+	if reports := options.Reports(); !reflect.DeepEqual(reports, []Report{{Format: "pretty", Path: "pretty.txt"}}) {
+		t.Errorf("Reports() = %v", reports)
+	}
+}
+
+func TestParseOptionsValuesAreNotFeaturePaths(t *testing.T) {
+	options, err := ParseOptions([]string{
+		"--order", "random", "--order=random:1234",
+		"-j", "lib/helpers.jar", "--jars", "lib/other.jar",
+		"--i18n-keywords", "hu", "--i18n", "en",
+		"features/login.feature",
+	})
+	if err != nil {
+		t.Fatalf("ParseOptions() error: %s", err)
+	}
+
+	if paths := options.FeaturePaths(); !reflect.DeepEqual(paths, []string{"features/login.feature"}) {
+		t.Errorf("FeaturePaths() = %v, want the feature only", paths)
+	}
+	wantArgs := "--order random --order random:1234 --jars lib/helpers.jar --jars lib/other.jar --i18n-keywords hu --i18n en features/login.feature"
+	if args := strings.Join(options.Args(), " "); args != wantArgs {
+		t.Errorf("Args() = %s, want: %s", args, wantArgs)
+	}
+}
+
+func TestParseOptionsErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"--format", "html", "--out"},
+		{"features", "--order"},
+		{"--out=", "report.html"},
+		{"--format", "--out", "report.html"},
+		{"--format", "html", "--out", "a.html", "--out", "b.html"},
+		{"--format", "html", "--format", "progress"},
+		{"--format", "html", "--out", "report", "--format", "json", "--out", "report"},
+	} {
+		if _, err := ParseOptions(args); err == nil {
+			t.Errorf("ParseOptions(%v) succeeded, want an error", args)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package cucumber
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+// Status ...
+type Status string
+
+// Step and scenario statuses used by the cucumber json formatter.
+const (
+	StatusPassed    Status = "passed"
+	StatusFailed    Status = "failed"
+	StatusSkipped   Status = "skipped"
+	StatusUndefined Status = "undefined"
+	StatusPending   Status = "pending"
+	StatusAmbiguous Status = "ambiguous"
+)
+
+// Result ...
+type Result struct {
+	Status       Status `json:"status"`
+	Duration     int64  `json:"duration"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// Tag ...
+type Tag struct {
+	Name string `json:"name"`
+	Line int    `json:"line"`
+}
+
+// Match ...
+type Match struct {
+	Location string `json:"location"`
+}
+
+// Embedding ...
+type Embedding struct {
+	MimeType string `json:"mime_type"`
+	Data     string `json:"data"`
+}
+
+// Hook ...
+type Hook struct {
+	Match      Match       `json:"match"`
+	Result     Result      `json:"result"`
+	Embeddings []Embedding `json:"embeddings"`
+}
+
+// Step ...
+type Step struct {
+	Keyword    string      `json:"keyword"`
+	Name       string      `json:"name"`
+	Line       int         `json:"line"`
+	Match      Match       `json:"match"`
+	Result     Result      `json:"result"`
+	Embeddings []Embedding `json:"embeddings"`
+}
+
+// Element is a scenario, a scenario outline example or a background of a feature.
+type Element struct {
+	ID          string `json:"id"`
+	Keyword     string `json:"keyword"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Line        int    `json:"line"`
+	Type        string `json:"type"`
+	Tags        []Tag  `json:"tags"`
+	Before      []Hook `json:"before"`
+	Steps       []Step `json:"steps"`
+	After       []Hook `json:"after"`
+}
+
+// Feature ...
+type Feature struct {
+	ID          string    `json:"id"`
+	URI         string    `json:"uri"`
+	Keyword     string    `json:"keyword"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Line        int       `json:"line"`
+	Tags        []Tag     `json:"tags"`
+	Elements    []Element `json:"elements"`
+}
+
+// Scenario is a scenario element together with the steps of the background preceding it.
+type Scenario struct {
+	Element
+
+	FeatureName     string
+	FeatureURI      string
+	FeatureTags     []Tag
+	BackgroundSteps []Step
+}
+
+// Scenarios returns the scenarios of the feature, backgrounds are folded into the scenario they precede.
+func (feature Feature) Scenarios() []Scenario {
+	scenarios := []Scenario{}
+
+	var backgroundSteps []Step
+	for _, element := range feature.Elements {
+		if element.Type == "background" {
+			backgroundSteps = element.Steps
+			continue
+		}
+
+		scenarios = append(scenarios, Scenario{
+			Element:         element,
+			FeatureName:     feature.Name,
+			FeatureURI:      feature.URI,
+			FeatureTags:     feature.Tags,
+			BackgroundSteps: backgroundSteps,
+		})
+		backgroundSteps = nil
+	}
+
+	return scenarios
+}
+
+// Location returns the feature file path and line of the scenario, like: features/login.feature:12
+func (scenario Scenario) Location() string {
+	return scenario.FeatureURI + ":" + strconv.Itoa(scenario.Line)
+}
+
+func (scenario Scenario) results() []Result {
+	results := []Result{}
+	for _, hook := range scenario.Before {
+		results = append(results, hook.Result)
+	}
+	for _, step := range scenario.BackgroundSteps {
+		results = append(results, step.Result)
+	}
+	for _, step := range scenario.Steps {
+		results = append(results, step.Result)
+	}
+	for _, hook := range scenario.After {
+		results = append(results, hook.Result)
+	}
+	return results
+}
+
+// failed reports whether the step or hook failed, an ambiguous step match fails the step as well.
+func (result Result) failed() bool {
+	return result.Status == StatusFailed || result.Status == StatusAmbiguous
+}
+
+// Status returns the overall status of the scenario,
+// a single failing step or hook fails the whole scenario.
+func (scenario Scenario) Status() Status {
+	has := map[Status]bool{}
+	for _, result := range scenario.results() {
+		switch {
+		case result.failed():
+			has[StatusFailed] = true
+		case result.Status == StatusPassed, result.Status == StatusUndefined, result.Status == StatusPending:
+			has[result.Status] = true
+		default:
+			// skipped, and the steps without result (older cucumber versions leave it out for the steps not run)
+			has[StatusSkipped] = true
+		}
+	}
+
+	for _, status := range []Status{StatusFailed, StatusUndefined, StatusPending, StatusSkipped} {
+		if has[status] {
+			return status
+		}
+	}
+	return StatusPassed
+}
+
+// FailingStep returns the description and the error message of the first failing step or hook.
+func (scenario Scenario) FailingStep() (string, string) {
+	for _, hook := range scenario.Before {
+		if hook.Result.failed() {
+			return "Before hook " + hook.Match.Location, hook.Result.ErrorMessage
+		}
+	}
+	steps := append(append([]Step{}, scenario.BackgroundSteps...), scenario.Steps...)
+	for _, step := range steps {
+		if step.Result.failed() {
+			return strings.TrimSpace(step.Keyword) + " " + step.Name, step.Result.ErrorMessage
+		}
+	}
+	for _, hook := range scenario.After {
+		if hook.Result.failed() {
+			return "After hook " + hook.Match.Location, hook.Result.ErrorMessage
+		}
+	}
+	return "", ""
+}
+
+// Duration returns the summed duration of the scenario's steps and hooks.
+func (scenario Scenario) Duration() time.Duration {
+	var duration int64
+	for _, result := range scenario.results() {
+		duration += result.Duration
+	}
+	return time.Duration(duration)
+}
+
+// Summary ...
+type Summary struct {
+	Passed    int
+	Failed    int
+	Skipped   int
+	Undefined int
+	Pending   int
+
+	Duration time.Duration
+}
+
+// Total ...
+func (summary Summary) Total() int {
+	return summary.Passed + summary.Failed + summary.Skipped + summary.Undefined + summary.Pending
+}
+
+// NewSummary counts the scenarios of the given features by status.
+func NewSummary(features []Feature) Summary {
+	summary := Summary{}
+	for _, feature := range features {
+		for _, scenario := range feature.Scenarios() {
+			switch scenario.Status() {
+			case StatusPassed:
+				summary.Passed++
+			case StatusFailed:
+				summary.Failed++
+			case StatusSkipped:
+				summary.Skipped++
+			case StatusUndefined:
+				summary.Undefined++
+			case StatusPending:
+				summary.Pending++
+			}
+			summary.Duration += scenario.Duration()
+		}
+	}
+	return summary
+}
+
+// ParseReport decodes a cucumber json report.
+func ParseReport(reader io.Reader) ([]Feature, error) {
+	features := []Feature{}
+	if err := json.NewDecoder(reader).Decode(&features); err != nil {
+		if err == io.EOF {
+			// cucumber leaves an empty report behind if no feature was run
+			return []Feature{}, nil
+		}
+		return nil, err
+	}
+	return features, nil
+}
+
+// ParseReportFile decodes the cucumber json report at the given path.
+func ParseReportFile(pth string) (features []Feature, err error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	return ParseReport(file)
+}
+
+// WriteReportFile writes the features as a cucumber json report.
+func WriteReportFile(pth string, features []Feature) error {
+	content, err := json.Marshal(features)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, content)
+}
+
+// scenarioElements is a scenario element together with the background element preceding it.
+type scenarioElements struct {
+	background *Element
+	scenario   Element
+}
+
+func (elements scenarioElements) list() []Element {
+	if elements.background != nil {
+		return []Element{*elements.background, elements.scenario}
+	}
+	return []Element{elements.scenario}
+}
+
+// MergeRerun replaces the scenarios of the features with the scenarios of the same location in the rerun report.
+// It returns the merged features and the scenarios which failed originally, but passed on the rerun.
+func MergeRerun(features []Feature, rerun []Feature) ([]Feature, []Scenario) {
+	rerunElements := map[string]scenarioElements{}
+	for _, feature := range rerun {
+		var background *Element
+		for i, element := range feature.Elements {
+			if element.Type == "background" {
+				background = &feature.Elements[i]
+				continue
+			}
+			rerunElements[feature.URI+":"+strconv.Itoa(element.Line)] = scenarioElements{background: background, scenario: element}
+			background = nil
+		}
+	}
+
+	fixed := []Scenario{}
+	merged := []Feature{}
+	for _, feature := range features {
+		originalScenarios := feature.Scenarios()
+
+		elements := []Element{}
+		scenarioIdx := 0
+		var background *Element
+		for i, element := range feature.Elements {
+			if element.Type == "background" {
+				background = &feature.Elements[i]
+				continue
+			}
+
+			originalScenario := originalScenarios[scenarioIdx]
+			scenarioIdx++
+
+			current := scenarioElements{background: background, scenario: element}
+			background = nil
+
+			if rerunElement, ok := rerunElements[originalScenario.Location()]; ok {
+				rerunFeature := Feature{URI: feature.URI, Name: feature.Name, Elements: rerunElement.list()}
+				rerunScenario := rerunFeature.Scenarios()[0]
+				if originalScenario.Status() == StatusFailed && rerunScenario.Status() == StatusPassed {
+					fixed = append(fixed, rerunScenario)
+				}
+				current = rerunElement
+			}
+
+			elements = append(elements, current.list()...)
+		}
+
+		feature.Elements = elements
+		merged = append(merged, feature)
+	}
+
+	return merged, fixed
+}
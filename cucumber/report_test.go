@@ -0,0 +1,79 @@
+package cucumber
+
+import (
+	"strings"
+	"testing"
+)
+
+const testStatusReport = `[
+  {
+    "uri": "features/login.feature",
+    "name": "Login",
+    "elements": [
+      {
+        "name": "Not run step", "line": 3, "type": "scenario",
+        "steps": [
+          {"keyword": "Given ", "name": "I am on the login screen", "result": {"status": "passed"}},
+          {"keyword": "Then ", "name": "I see the home screen"}
+        ]
+      },
+      {
+        "name": "Ambiguous step", "line": 8, "type": "scenario",
+        "steps": [
+          {"keyword": "Given ", "name": "I am on the login screen", "result": {"status": "ambiguous", "error_message": "Ambiguous match"}},
+          {"keyword": "Then ", "name": "I see the home screen", "result": {"status": "skipped"}}
+        ]
+      },
+      {
+        "name": "Unknown status", "line": 13, "type": "scenario",
+        "steps": [
+          {"keyword": "Given ", "name": "I am on the login screen", "result": {"status": "passed"}},
+          {"keyword": "Then ", "name": "I see the home screen", "result": {"status": "unused"}}
+        ]
+      },
+      {
+        "name": "Failed step", "line": 18, "type": "scenario",
+        "steps": [
+          {"keyword": "Given ", "name": "I am on the login screen", "result": {"status": "failed", "error_message": "element not found"}},
+          {"keyword": "Then ", "name": "I see the home screen"}
+        ]
+      }
+    ]
+  }
+]`
+
+func TestScenarioStatus(t *testing.T) {
+	features, err := ParseReport(strings.NewReader(testStatusReport))
+	if err != nil {
+		t.Fatalf("ParseReport() error: %s", err)
+	}
+
+	scenarios := features[0].Scenarios()
+	want := []struct {
+		status  Status
+		step    string
+		message string
+	}{
+		{status: StatusSkipped},
+		{status: StatusFailed, step: "Given I am on the login screen", message: "Ambiguous match"},
+		{status: StatusSkipped},
+		{status: StatusFailed, step: "Given I am on the login screen", message: "element not found"},
+	}
+	if len(scenarios) != len(want) {
+		t.Fatalf("scenarios: %d, want: %d", len(scenarios), len(want))
+	}
+
+	for i, scenario := range scenarios {
+		if status := scenario.Status(); status != want[i].status {
+			t.Errorf("%s: status %s, want: %s", scenario.Name, status, want[i].status)
+		}
+		if step, message := scenario.FailingStep(); step != want[i].step || message != want[i].message {
+			t.Errorf("%s: failing step %q (%q), want: %q (%q)", scenario.Name, step, message, want[i].step, want[i].message)
+		}
+	}
+
+	summary := NewSummary(features)
+	if summary.Failed != 2 || summary.Skipped != 2 || summary.Total() != 4 {
+		t.Fatalf("summary: %+v", summary)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/command/rubycommand"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+)
+
+// commandExecutor creates and runs the external commands of the step (adb, aapt, gem, bundle, keytool, calabash-android, envman).
+type commandExecutor interface {
+	// NewRubyCommand creates a command of a ruby tool, like gem, bundle or calabash-android.
+	// The command is prefixed with sudo if the system ruby requires it.
+	NewRubyCommand(args ...string) (*command.Model, error)
+	// NewGemInstallCommands creates the commands, which install the gem (the latest version if no version is given).
+	NewGemInstallCommands(gem, version string) ([]*command.Model, error)
+	// Run runs the command with its configured outputs.
+	Run(cmd *command.Model) error
+	// RunAndReturnTrimmedCombinedOutput runs the command and returns its output.
+	RunAndReturnTrimmedCombinedOutput(cmd *command.Model) (string, error)
+	// Start starts the command in the background, Wait waits for it to exit.
+	Start(cmd *command.Model) error
+	Wait(cmd *command.Model) error
+	// Signal sends the signal to the running command, or to its process group if it was started in its own process group.
+	Signal(cmd *command.Model, sig syscall.Signal) error
+}
+
+// osCommandExecutor runs the commands on the host.
+type osCommandExecutor struct{}
+
+func (osCommandExecutor) NewRubyCommand(args ...string) (*command.Model, error) {
+	return rubycommand.NewFromSlice(args...)
+}
+
+func (osCommandExecutor) NewGemInstallCommands(gem, version string) ([]*command.Model, error) {
+	return rubycommand.GemInstall(gem, version)
+}
+
+func (osCommandExecutor) Run(cmd *command.Model) error {
+	startTime := time.Now()
+	err := cmd.Run()
+	logCommandResult(cmd, startTime, err)
+	return err
+}
+
+func (osCommandExecutor) RunAndReturnTrimmedCombinedOutput(cmd *command.Model) (string, error) {
+	startTime := time.Now()
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	logCommandResult(cmd, startTime, err)
+	return out, err
+}
+
+func (osCommandExecutor) Start(cmd *command.Model) error {
+	return cmd.GetCmd().Start()
+}
+
+func (osCommandExecutor) Wait(cmd *command.Model) error {
+	return cmd.GetCmd().Wait()
+}
+
+func (osCommandExecutor) Signal(cmd *command.Model, sig syscall.Signal) error {
+	process := cmd.GetCmd().Process
+	if process == nil {
+		return errors.New("command not started")
+	}
+	pid := process.Pid
+	if attr := cmd.GetCmd().SysProcAttr; attr != nil && attr.Setpgid {
+		// the negative pid addresses the process group
+		pid = -pid
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// commandSource returns the name of the tool the command runs, the source of its output in the log.
+func commandSource(args []string) string {
+	if len(args) > 0 && args[0] == "sudo" {
+		args = args[1:]
+	}
+	if len(args) > 2 && args[0] == "bundle" && args[1] == "exec" {
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	return filepath.Base(args[0])
+}
+
+// logCommand prints the command, the args are a structured field in json log format.
+func logCommand(cmd *command.Model) {
+	logger.With(logger.Fields{"args": cmd.GetCmd().Args}).Printf("$ %s", cmd.PrintableCommandArgs())
+}
+
+// logCommandResult prints the exit code and the duration of the command in json log format.
+// The args are not included, they may contain passwords.
+func logCommandResult(cmd *command.Model, startTime time.Time, err error) {
+	exitCode := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		exitCode = -1
+	}
+
+	source := commandSource(cmd.GetCmd().Args)
+	logger.With(logger.Fields{
+		"command":     source,
+		"exit_code":   exitCode,
+		"duration_ms": time.Since(startTime).Milliseconds(),
+	}).Debugf("%s finished with exit code: %d", source, exitCode)
+}
+
+// setCommandOutput forwards the output of the command into the step's log,
+// the returned function flushes the output in json log format.
+func setCommandOutput(cmd *command.Model) func() {
+	source := commandSource(cmd.GetCmd().Args)
+	stdout := logger.NewOutputWriter(source, os.Stdout, nil)
+	stderr := logger.NewOutputWriter(source, os.Stderr, logger.Fields{"stream": "stderr"})
+
+	cmd.SetStdout(stdout).SetStderr(stderr)
+
+	return func() {
+		for _, w := range []*logger.OutputWriter{stdout, stderr} {
+			if err := w.Flush(); err != nil {
+				logger.Warnf("Failed to write the output of %s, error: %s", source, err)
+			}
+		}
+	}
+}
+
+// cmdExecutor is used by every stage of the step, tests replace it with a fake.
+var cmdExecutor commandExecutor = osCommandExecutor{}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/command"
+)
+
+// invocation is a command run through the fake executor.
+type invocation struct {
+	args []string
+	// envs are the envs set on top of the step's environment
+	envs  []string
+	dir   string
+	stdin string
+}
+
+func (i invocation) String() string {
+	return strings.Join(i.args, " ")
+}
+
+// fakeResponse is the scripted result of a command.
+type fakeResponse struct {
+	out string
+	err error
+	// hang blocks the command until it is signaled
+	hang bool
+	// ignoreInterrupt makes the hanging command exit only if it is killed
+	ignoreInterrupt bool
+}
+
+// fakeCommandExecutor records the commands instead of running them, and returns the scripted responses.
+type fakeCommandExecutor struct {
+	invocations []invocation
+	// responses are keyed by command prefix (the args joined by space), the longest matching prefix wins
+	responses map[string]fakeResponse
+	// systemRuby makes the ruby commands prefixed with sudo, like with the system ruby
+	systemRuby bool
+
+	// mutex guards the invocations, responses, started commands and signals, the commands of the parallel mode run concurrently
+	mutex    sync.Mutex
+	started  map[*command.Model]fakeResponse
+	signals  []syscall.Signal
+	signaled chan struct{}
+	killed   chan struct{}
+}
+
+// withFakeExecutor replaces the command executor of the step for the test.
+func withFakeExecutor(t *testing.T) *fakeCommandExecutor {
+	fake := &fakeCommandExecutor{
+		responses: map[string]fakeResponse{},
+		started:   map[*command.Model]fakeResponse{},
+		signaled:  make(chan struct{}),
+		killed:    make(chan struct{}),
+	}
+
+	original := cmdExecutor
+	cmdExecutor = fake
+	t.Cleanup(func() { cmdExecutor = original })
+
+	return fake
+}
+
+func (fake *fakeCommandExecutor) respond(prefix, out string, err error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.responses[prefix] = fakeResponse{out: out, err: err}
+}
+
+func (fake *fakeCommandExecutor) NewRubyCommand(args ...string) (*command.Model, error) {
+	if fake.systemRuby && len(args) > 1 && (args[0] == "gem" || args[0] == "bundle") && args[1] == "install" {
+		args = append([]string{"sudo"}, args...)
+	}
+	return command.NewFromSlice(args...)
+}
+
+func (fake *fakeCommandExecutor) NewGemInstallCommands(gem, version string) ([]*command.Model, error) {
+	args := []string{"gem", "install", gem, "--no-document"}
+	if version != "" {
+		args = append(args, "-v", version)
+	}
+
+	cmd, err := fake.NewRubyCommand(args...)
+	if err != nil {
+		return nil, err
+	}
+	return []*command.Model{cmd}, nil
+}
+
+func (fake *fakeCommandExecutor) record(cmd *command.Model) fakeResponse {
+	execCmd := cmd.GetCmd()
+
+	inv := invocation{
+		args: execCmd.Args,
+		envs: addedEnvs(execCmd.Env),
+		dir:  execCmd.Dir,
+	}
+	if execCmd.Stdin != nil {
+		if stdin, err := ioutil.ReadAll(execCmd.Stdin); err == nil {
+			inv.stdin = string(stdin)
+		}
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.invocations = append(fake.invocations, inv)
+
+	joined := inv.String()
+	response, matched := fakeResponse{}, ""
+	for prefix, r := range fake.responses {
+		if strings.HasPrefix(joined, prefix) && len(prefix) >= len(matched) {
+			response, matched = r, prefix
+		}
+	}
+	return response
+}
+
+// exit blocks the hanging command until it is signaled, and returns the result of the command.
+func (fake *fakeCommandExecutor) exit(response fakeResponse) error {
+	if !response.hang {
+		return response.err
+	}
+	if response.ignoreInterrupt {
+		<-fake.killed
+		return errors.New("signal: killed")
+	}
+	<-fake.signaled
+	return errors.New("signal: interrupt")
+}
+
+func (fake *fakeCommandExecutor) Run(cmd *command.Model) error {
+	response := fake.record(cmd)
+	if response.out != "" && cmd.GetCmd().Stdout != nil {
+		if _, err := cmd.GetCmd().Stdout.Write([]byte(response.out)); err != nil {
+			return err
+		}
+	}
+	return fake.exit(response)
+}
+
+// Start records the command, its result is returned by Wait.
+func (fake *fakeCommandExecutor) Start(cmd *command.Model) error {
+	response := fake.record(cmd)
+	if response.out != "" && cmd.GetCmd().Stdout != nil {
+		if _, err := cmd.GetCmd().Stdout.Write([]byte(response.out)); err != nil {
+			return err
+		}
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.started[cmd] = response
+	return nil
+}
+
+func (fake *fakeCommandExecutor) Wait(cmd *command.Model) error {
+	fake.mutex.Lock()
+	response, ok := fake.started[cmd]
+	fake.mutex.Unlock()
+
+	if !ok {
+		return errors.New("command not started")
+	}
+	return fake.exit(response)
+}
+
+func (fake *fakeCommandExecutor) RunAndReturnTrimmedCombinedOutput(cmd *command.Model) (string, error) {
+	response := fake.record(cmd)
+	return strings.TrimSpace(response.out), response.err
+}
+
+func (fake *fakeCommandExecutor) Signal(cmd *command.Model, sig syscall.Signal) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if _, ok := fake.started[cmd]; !ok {
+		return errors.New("command not started")
+	}
+
+	if len(fake.signals) == 0 {
+		close(fake.signaled)
+	}
+	if sig == syscall.SIGKILL {
+		close(fake.killed)
+	}
+	fake.signals = append(fake.signals, sig)
+	return nil
+}
+
+// commands returns the invoked commands, without the envman exports.
+func (fake *fakeCommandExecutor) commands() []string {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	commands := []string{}
+	for _, inv := range fake.invocations {
+		if len(inv.args) > 0 && inv.args[0] == "envman" {
+			continue
+		}
+		commands = append(commands, inv.String())
+	}
+	return commands
+}
+
+// exports returns the envs exported with envman.
+func (fake *fakeCommandExecutor) exports() map[string]string {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	exports := map[string]string{}
+	for _, inv := range fake.invocations {
+		if len(inv.args) == 4 && inv.args[0] == "envman" && inv.args[1] == "add" {
+			exports[inv.args[3]] = inv.stdin
+		}
+	}
+	return exports
+}
+
+func requireCommands(t *testing.T, fake *fakeCommandExecutor, want ...string) {
+	t.Helper()
+
+	got := fake.commands()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("commands:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,231 @@
+package gemfilelock
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+// Source types of a Gemfile.lock.
+const (
+	SourceGem    = "GEM"
+	SourceGit    = "GIT"
+	SourcePath   = "PATH"
+	SourcePlugin = "PLUGIN SOURCE"
+)
+
+const (
+	sectionPlatforms    = "PLATFORMS"
+	sectionDependencies = "DEPENDENCIES"
+	sectionRubyVersion  = "RUBY VERSION"
+	sectionBundledWith  = "BUNDLED WITH"
+)
+
+// Dependency is a gem requirement, like: cucumber (~> 2.0, >= 2.0.2)
+type Dependency struct {
+	Name         string
+	Requirements []string
+	// Pinned is true if the dependency comes from a non-default source (marked with ! in the DEPENDENCIES section).
+	Pinned bool
+}
+
+// Spec is a resolved gem, like: nokogiri (1.10.1-x86_64-darwin)
+type Spec struct {
+	Name         string
+	Version      string
+	Platform     string
+	Dependencies []Dependency
+}
+
+// Source is a GEM, GIT, PATH or PLUGIN SOURCE section with its specs.
+type Source struct {
+	Type    string
+	Remotes []string
+	// Options holds the other attributes of the source, like revision, branch, tag or glob.
+	Options map[string]string
+	Specs   []Spec
+}
+
+func (source Source) String() string {
+	s := source.Type
+	if len(source.Remotes) > 0 {
+		s += " " + strings.Join(source.Remotes, ", ")
+	}
+	for _, key := range []string{"revision", "branch", "tag", "ref"} {
+		if value, ok := source.Options[key]; ok {
+			s += fmt.Sprintf(" (%s: %s)", key, value)
+		}
+	}
+	return s
+}
+
+// Lockfile ...
+type Lockfile struct {
+	Sources      []Source
+	Platforms    []string
+	Dependencies []Dependency
+	RubyVersion  string
+	BundledWith  string
+}
+
+// Spec returns the spec of the gem with the given name, and the source it comes from.
+func (lockfile Lockfile) Spec(name string) (Spec, Source, bool) {
+	for _, source := range lockfile.Sources {
+		for _, spec := range source.Specs {
+			if spec.Name == name {
+				return spec, source, true
+			}
+		}
+	}
+	return Spec{}, Source{}, false
+}
+
+// Dependency returns the top level dependency with the given name.
+func (lockfile Lockfile) Dependency(name string) (Dependency, bool) {
+	for _, dependency := range lockfile.Dependencies {
+		if dependency.Name == name {
+			return dependency, true
+		}
+	}
+	return Dependency{}, false
+}
+
+// name (requirements or version)
+var entryRegexp = regexp.MustCompile(`^([^\s(!]+)(?: \((.*)\))?(!)?$`)
+
+func parseDependency(entry string) (Dependency, error) {
+	match := entryRegexp.FindStringSubmatch(entry)
+	if match == nil {
+		return Dependency{}, fmt.Errorf("invalid dependency: %s", entry)
+	}
+
+	dependency := Dependency{Name: match[1], Pinned: match[3] == "!"}
+	if match[2] != "" {
+		for _, requirement := range strings.Split(match[2], ",") {
+			dependency.Requirements = append(dependency.Requirements, strings.TrimSpace(requirement))
+		}
+	}
+	return dependency, nil
+}
+
+func parseSpec(entry string) (Spec, error) {
+	match := entryRegexp.FindStringSubmatch(entry)
+	if match == nil || match[2] == "" {
+		return Spec{}, fmt.Errorf("invalid spec: %s", entry)
+	}
+
+	spec := Spec{Name: match[1], Version: match[2]}
+	// gem versions contain no dash, the rest is the platform
+	if split := strings.SplitN(match[2], "-", 2); len(split) == 2 {
+		spec.Version = split[0]
+		spec.Platform = split[1]
+	}
+	return spec, nil
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// Parse parses the content of a Gemfile.lock.
+func Parse(content string) (Lockfile, error) {
+	lockfile := Lockfile{}
+
+	section := ""
+	var source *Source
+	var spec *Spec
+	inSpecs := false
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		indent := indentation(line)
+
+		if indent == 0 {
+			section = trimmed
+			source, spec, inSpecs = nil, nil, false
+
+			switch section {
+			case SourceGem, SourceGit, SourcePath, SourcePlugin:
+				lockfile.Sources = append(lockfile.Sources, Source{Type: section, Options: map[string]string{}})
+				source = &lockfile.Sources[len(lockfile.Sources)-1]
+			}
+			continue
+		}
+
+		switch section {
+		case SourceGem, SourceGit, SourcePath, SourcePlugin:
+			switch {
+			case indent == 2:
+				spec = nil
+				inSpecs = trimmed == "specs:"
+				if inSpecs {
+					continue
+				}
+
+				split := strings.SplitN(trimmed, ":", 2)
+				if len(split) != 2 {
+					return Lockfile{}, fmt.Errorf("line %d: invalid source attribute: %s", lineNum, trimmed)
+				}
+				key, value := split[0], strings.TrimSpace(split[1])
+				if key == "remote" {
+					source.Remotes = append(source.Remotes, value)
+				} else {
+					source.Options[key] = value
+				}
+			case indent == 4 && inSpecs:
+				s, err := parseSpec(trimmed)
+				if err != nil {
+					return Lockfile{}, fmt.Errorf("line %d: %s", lineNum, err)
+				}
+				source.Specs = append(source.Specs, s)
+				spec = &source.Specs[len(source.Specs)-1]
+			case indent == 6 && spec != nil:
+				dependency, err := parseDependency(trimmed)
+				if err != nil {
+					return Lockfile{}, fmt.Errorf("line %d: %s", lineNum, err)
+				}
+				spec.Dependencies = append(spec.Dependencies, dependency)
+			default:
+				return Lockfile{}, fmt.Errorf("line %d: unexpected line in %s section: %s", lineNum, section, trimmed)
+			}
+		case sectionPlatforms:
+			lockfile.Platforms = append(lockfile.Platforms, trimmed)
+		case sectionDependencies:
+			dependency, err := parseDependency(trimmed)
+			if err != nil {
+				return Lockfile{}, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+			lockfile.Dependencies = append(lockfile.Dependencies, dependency)
+		case sectionRubyVersion:
+			lockfile.RubyVersion = trimmed
+		case sectionBundledWith:
+			lockfile.BundledWith = trimmed
+		}
+		// unknown sections are skipped, newer bundler versions may add sections (like CHECKSUMS)
+	}
+	if err := scanner.Err(); err != nil {
+		return Lockfile{}, err
+	}
+
+	return lockfile, nil
+}
+
+// ParseFile parses the Gemfile.lock at the given path.
+func ParseFile(pth string) (Lockfile, error) {
+	content, err := fileutil.ReadStringFromFile(pth)
+	if err != nil {
+		return Lockfile{}, err
+	}
+	return Parse(content)
+}
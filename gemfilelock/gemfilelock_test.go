@@ -0,0 +1,212 @@
+package gemfilelock
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testLockfile = `GIT
+  remote: https://github.com/calabash/calabash-android.git
+  revision: 4ba1c3bf0d1a8d2e5c9b7a6f3e2d1c0b9a8f7e6d
+  branch: develop
+  specs:
+    calabash-android (0.9.9)
+      cucumber (~> 2.0)
+      json (>= 1.8, < 3.0)
+
+PATH
+  remote: vendor/gems/test-helpers
+  specs:
+    test-helpers (0.1.0)
+
+GEM
+  remote: https://rubygems.org/
+  specs:
+    cucumber (2.99.0)
+      builder (>= 2.1.2)
+    json (2.3.1)
+
+GEM
+  remote: https://gems.example.com/
+  specs:
+    nokogiri (1.10.10-x86_64-linux)
+
+PLUGIN SOURCE
+  remote: https://plugins.example.com/
+  type: rubygems
+  specs:
+    bundler-plugin (1.0.0)
+
+PLATFORMS
+  ruby
+  x86_64-linux
+
+DEPENDENCIES
+  calabash-android!
+  cucumber (~> 2.0)
+  test-helpers!
+
+RUBY VERSION
+   ruby 2.7.2p137
+
+BUNDLED WITH
+   2.1.4
+`
+
+func TestParse(t *testing.T) {
+	lockfile, err := Parse(testLockfile)
+	if err != nil {
+		t.Fatalf("Parse() error: %s", err)
+	}
+
+	var types []string
+	for _, source := range lockfile.Sources {
+		types = append(types, source.Type)
+	}
+	if want := []string{SourceGit, SourcePath, SourceGem, SourceGem, SourcePlugin}; !reflect.DeepEqual(types, want) {
+		t.Fatalf("source types = %v, want: %v", types, want)
+	}
+
+	if git := lockfile.Sources[0]; git.String() != "GIT https://github.com/calabash/calabash-android.git (revision: 4ba1c3bf0d1a8d2e5c9b7a6f3e2d1c0b9a8f7e6d) (branch: develop)" {
+		t.Errorf("GIT source = %s", git)
+	}
+	if plugin := lockfile.Sources[4]; plugin.Options["type"] != "rubygems" || len(plugin.Specs) != 1 || plugin.Specs[0].Name != "bundler-plugin" {
+		t.Errorf("PLUGIN SOURCE = %+v", plugin)
+	}
+
+	if want := []string{"ruby", "x86_64-linux"}; !reflect.DeepEqual(lockfile.Platforms, want) {
+		t.Errorf("Platforms = %v, want: %v", lockfile.Platforms, want)
+	}
+	wantDependencies := []Dependency{
+		{Name: "calabash-android", Pinned: true},
+		{Name: "cucumber", Requirements: []string{"~> 2.0"}},
+		{Name: "test-helpers", Pinned: true},
+	}
+	if !reflect.DeepEqual(lockfile.Dependencies, wantDependencies) {
+		t.Errorf("Dependencies = %+v, want: %+v", lockfile.Dependencies, wantDependencies)
+	}
+	if dependency, ok := lockfile.Dependency("calabash-android"); !ok || !dependency.Pinned {
+		t.Errorf("Dependency(calabash-android) = %+v, %v, want it pinned", dependency, ok)
+	}
+	if lockfile.RubyVersion != "ruby 2.7.2p137" || lockfile.BundledWith != "2.1.4" {
+		t.Errorf("RubyVersion = %s, BundledWith = %s", lockfile.RubyVersion, lockfile.BundledWith)
+	}
+}
+
+func TestLockfileSpec(t *testing.T) {
+	lockfile, err := Parse(testLockfile)
+	if err != nil {
+		t.Fatalf("Parse() error: %s", err)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		wantSpec   Spec
+		wantSource string
+	}{
+		{
+			name: "calabash-android",
+			wantSpec: Spec{Name: "calabash-android", Version: "0.9.9", Dependencies: []Dependency{
+				{Name: "cucumber", Requirements: []string{"~> 2.0"}},
+				{Name: "json", Requirements: []string{">= 1.8", "< 3.0"}},
+			}},
+			wantSource: SourceGit,
+		},
+		{name: "test-helpers", wantSpec: Spec{Name: "test-helpers", Version: "0.1.0"}, wantSource: SourcePath},
+		{name: "json", wantSpec: Spec{Name: "json", Version: "2.3.1"}, wantSource: SourceGem},
+		// the spec of the second GEM section
+		{name: "nokogiri", wantSpec: Spec{Name: "nokogiri", Version: "1.10.10", Platform: "x86_64-linux"}, wantSource: SourceGem},
+	} {
+		spec, source, ok := lockfile.Spec(tt.name)
+		if !ok {
+			t.Errorf("Spec(%s) not found", tt.name)
+			continue
+		}
+		if !reflect.DeepEqual(spec, tt.wantSpec) || source.Type != tt.wantSource {
+			t.Errorf("Spec(%s) = %+v from %s, want: %+v from %s", tt.name, spec, source.Type, tt.wantSpec, tt.wantSource)
+		}
+	}
+
+	if nokogiri, source, _ := lockfile.Spec("nokogiri"); !reflect.DeepEqual(source.Remotes, []string{"https://gems.example.com/"}) {
+		t.Errorf("Spec(%s) source = %s, want the second GEM section", nokogiri.Name, source)
+	}
+	if _, _, ok := lockfile.Spec("rake"); ok {
+		t.Errorf("Spec(rake) found, want not found")
+	}
+}
+
+func TestParsePlatformSpec(t *testing.T) {
+	lockfile, err := Parse(`GEM
+  remote: https://rubygems.org/
+  specs:
+    calabash-android (0.9.8-x86_64-linux)
+      cucumber (~> 2.0)
+`)
+	if err != nil {
+		t.Fatalf("Parse() error: %s", err)
+	}
+
+	spec, _, ok := lockfile.Spec("calabash-android")
+	if !ok || spec.Version != "0.9.8" || spec.Platform != "x86_64-linux" {
+		t.Errorf("Spec(calabash-android) = %+v, want version 0.9.8 on x86_64-linux", spec)
+	}
+}
+
+func TestParseUnknownSection(t *testing.T) {
+	// newer bundler versions may add sections
+	lockfile, err := Parse(`GEM
+  remote: https://rubygems.org/
+  specs:
+    cucumber (2.99.0)
+
+CHECKSUMS
+  cucumber (2.99.0) sha256=0123456789abcdef
+
+BUNDLED WITH
+   2.5.3
+`)
+	if err != nil {
+		t.Fatalf("Parse() error: %s", err)
+	}
+	if _, _, ok := lockfile.Spec("cucumber"); !ok || lockfile.BundledWith != "2.5.3" {
+		t.Errorf("Parse() = %+v, want the unknown section skipped", lockfile)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, tt := range map[string]struct {
+		content string
+		want    string
+	}{
+		"invalid source attribute": {
+			content: "GEM\n  remote rubygems.org\n",
+			want:    "line 2: invalid source attribute",
+		},
+		"spec without version": {
+			content: "GEM\n  specs:\n    cucumber\n",
+			want:    "line 3: invalid spec: cucumber",
+		},
+		"invalid spec dependency": {
+			content: "GEM\n  specs:\n    cucumber (2.99.0)\n      builder (>= 2.1.2\n",
+			want:    "line 4: invalid dependency",
+		},
+		"spec outside specs": {
+			content: "GEM\n  remote: https://rubygems.org/\n    cucumber (2.99.0)\n",
+			want:    "line 3: unexpected line in GEM section",
+		},
+		"dependency without spec": {
+			content: "PATH\n  specs:\n      cucumber (~> 2.0)\n",
+			want:    "line 3: unexpected line in PATH section",
+		},
+		"invalid top level dependency": {
+			content: "DEPENDENCIES\n  calabash android\n",
+			want:    "line 2: invalid dependency",
+		},
+	} {
+		_, err := Parse(tt.content)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%s) error = %v, want: %s", name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package gems
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// IsConstraint reports whether the given gem version is a constraint (like ~> 0.9) rather than an exact version.
+func IsConstraint(gemVersion string) bool {
+	if strings.ContainsAny(gemVersion, "<>=~!,") {
+		return true
+	}
+	_, err := version.NewVersion(strings.TrimSpace(gemVersion))
+	return err != nil
+}
+
+// NewConstraints parses a gem version constraint, like: ~> 0.9 or >= 0.9.0, < 0.10
+func NewConstraints(constraint string) (version.Constraints, error) {
+	return version.NewConstraint(constraint)
+}
+
+// gem list prints a line per gem, like: calabash-android (0.9.8, 0.9.0)
+var gemListLineRegexp = regexp.MustCompile(`^(\S+) \((.*)\)$`)
+
+// VersionsFromGemList returns the versions of the gem listed by `gem list`, as listed.
+func VersionsFromGemList(out, gem string) []string {
+	versions := []string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		match := gemListLineRegexp.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil || match[1] != gem {
+			continue
+		}
+
+		for _, item := range strings.Split(match[2], ",") {
+			// default gems are listed like: default: 1.0.0, platform specific ones like: 1.10.1 x86_64-darwin
+			item = strings.TrimPrefix(strings.TrimSpace(item), "default: ")
+			if fields := strings.Fields(item); len(fields) > 0 {
+				versions = append(versions, fields[0])
+			}
+		}
+	}
+
+	return versions
+}
+
+// HighestMatching returns the highest of the versions which satisfies the constraints,
+// versions which can not be parsed are skipped.
+func HighestMatching(versions []string, constraints version.Constraints) (string, bool) {
+	highest := ""
+	var highestVersion *version.Version
+	for _, v := range versions {
+		parsed, err := version.NewVersion(v)
+		if err != nil {
+			continue
+		}
+		if !constraints.Check(parsed) {
+			continue
+		}
+		if highestVersion == nil || highestVersion.LessThan(parsed) {
+			highest, highestVersion = v, parsed
+		}
+	}
+	return highest, highestVersion != nil
+}
+
+// Highest returns the highest version.
+func Highest(versions []string) (string, error) {
+	constraints, err := version.NewConstraint(">= 0")
+	if err != nil {
+		return "", err
+	}
+	highest, found := HighestMatching(versions, constraints)
+	if !found {
+		return "", fmt.Errorf("no valid version in: %s", strings.Join(versions, ", "))
+	}
+	return highest, nil
+}
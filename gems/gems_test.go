@@ -0,0 +1,89 @@
+package gems
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsConstraint(t *testing.T) {
+	for gemVersion, want := range map[string]bool{
+		"0.9.8":            false,
+		" 0.9.8 ":          false,
+		"0.9":              false,
+		"~> 0.9":           true,
+		">= 0.9.0, < 0.10": true,
+		"= 0.9.8":          true,
+		"!= 0.9.7":         true,
+		"latest":           true,
+		"0.9.8, 0.9.9":     true,
+	} {
+		if got := IsConstraint(gemVersion); got != want {
+			t.Errorf("IsConstraint(%q) = %v, want: %v", gemVersion, got, want)
+		}
+	}
+}
+
+func TestVersionsFromGemList(t *testing.T) {
+	out := `*** LOCAL GEMS ***
+
+calabash-android (0.9.10, 0.9.8, 0.9.0)
+calabash-android-helpers (1.0.0)
+json (default: 2.3.0, 2.1.0)
+nokogiri (1.10.10 x86_64-linux, 1.10.1)`
+
+	for gem, want := range map[string][]string{
+		"calabash-android": {"0.9.10", "0.9.8", "0.9.0"},
+		"json":             {"2.3.0", "2.1.0"},
+		"nokogiri":         {"1.10.10", "1.10.1"},
+		"cucumber":         {},
+	} {
+		if versions := VersionsFromGemList(out, gem); !reflect.DeepEqual(versions, want) {
+			t.Errorf("VersionsFromGemList(%s) = %v, want: %v", gem, versions, want)
+		}
+	}
+}
+
+func TestHighestMatching(t *testing.T) {
+	versions := []string{"0.8.4", "0.9.0", "0.9.10", "0.9.8", "0.10.0", "1.0.0", "invalid"}
+
+	for _, tt := range []struct {
+		constraint string
+		want       string
+		wantFound  bool
+	}{
+		// like in rubygems, ~> 0.9 allows every 0.x version from 0.9 on
+		{constraint: "~> 0.9", want: "0.10.0", wantFound: true},
+		{constraint: "~> 0.9.0", want: "0.9.10", wantFound: true},
+		{constraint: ">= 0.9.0, < 0.10", want: "0.9.10", wantFound: true},
+		{constraint: "0.9.8", want: "0.9.8", wantFound: true},
+		{constraint: "= 0.8.4", want: "0.8.4", wantFound: true},
+		{constraint: "0.9.9", wantFound: false},
+		{constraint: "> 1.0.0", wantFound: false},
+	} {
+		constraints, err := NewConstraints(tt.constraint)
+		if err != nil {
+			t.Fatalf("NewConstraints(%s) error: %s", tt.constraint, err)
+		}
+		got, found := HighestMatching(versions, constraints)
+		if got != tt.want || found != tt.wantFound {
+			t.Errorf("HighestMatching(%s) = %s, %v, want: %s, %v", tt.constraint, got, found, tt.want, tt.wantFound)
+		}
+	}
+}
+
+func TestNewConstraintsInvalid(t *testing.T) {
+	for _, constraint := range []string{"", "latest", "~> ", ">= 0.9.0 < 0.10", "=> 0.9"} {
+		if _, err := NewConstraints(constraint); err == nil {
+			t.Errorf("NewConstraints(%q) succeeded, want an error", constraint)
+		}
+	}
+}
+
+func TestHighest(t *testing.T) {
+	if highest, err := Highest([]string{"0.9.8", "0.10.0", "0.9.10"}); err != nil || highest != "0.10.0" {
+		t.Errorf("Highest() = %s, %v, want: 0.10.0", highest, err)
+	}
+	if _, err := Highest([]string{"invalid"}); err == nil {
+		t.Errorf("Highest() succeeded without a valid version")
+	}
+}
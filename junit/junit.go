@@ -0,0 +1,152 @@
+package junit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+)
+
+// TestSuites ...
+type TestSuites struct {
+	XMLName    xml.Name    `xml:"testsuites"`
+	Name       string      `xml:"name,attr,omitempty"`
+	Tests      int         `xml:"tests,attr"`
+	Failures   int         `xml:"failures,attr"`
+	Errors     int         `xml:"errors,attr"`
+	Skipped    int         `xml:"skipped,attr"`
+	Time       string      `xml:"time,attr"`
+	TestSuites []TestSuite `xml:"testsuite"`
+}
+
+// TestSuite ...
+type TestSuite struct {
+	Name      string     `xml:"name,attr"`
+	Tests     int        `xml:"tests,attr"`
+	Failures  int        `xml:"failures,attr"`
+	Errors    int        `xml:"errors,attr"`
+	Skipped   int        `xml:"skipped,attr"`
+	Time      string     `xml:"time,attr"`
+	TestCases []TestCase `xml:"testcase"`
+}
+
+// TestCase ...
+type TestCase struct {
+	Name      string   `xml:"name,attr"`
+	ClassName string   `xml:"classname,attr"`
+	File      string   `xml:"file,attr,omitempty"`
+	Time      string   `xml:"time,attr"`
+	Failure   *Failure `xml:"failure,omitempty"`
+	Skipped   *Skipped `xml:"skipped,omitempty"`
+	SystemOut string   `xml:"system-out,omitempty"`
+}
+
+// Failure ...
+type Failure struct {
+	Message  string `xml:"message,attr"`
+	Type     string `xml:"type,attr,omitempty"`
+	Contents string `xml:",chardata"`
+}
+
+// Skipped ...
+type Skipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+func formatSeconds(duration time.Duration) string {
+	return fmt.Sprintf("%.6f", duration.Seconds())
+}
+
+func stepsOutput(scenario cucumber.Scenario) string {
+	lines := []string{}
+	steps := append(append([]cucumber.Step{}, scenario.BackgroundSteps...), scenario.Steps...)
+	for _, step := range steps {
+		line := strings.TrimSpace(step.Keyword) + " " + step.Name
+		lines = append(lines, fmt.Sprintf("%s...%s", line, step.Result.Status))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func newTestCase(scenario cucumber.Scenario) TestCase {
+	testCase := TestCase{
+		Name:      scenario.Name,
+		ClassName: scenario.FeatureName,
+		File:      scenario.Location(),
+		Time:      formatSeconds(scenario.Duration()),
+		SystemOut: stepsOutput(scenario),
+	}
+
+	switch status := scenario.Status(); status {
+	case cucumber.StatusFailed:
+		stepDescription, errorMessage := scenario.FailingStep()
+		message := strings.SplitN(strings.TrimSpace(errorMessage), "\n", 2)[0]
+		if message == "" {
+			message = "failed"
+		}
+		testCase.Failure = &Failure{
+			Message:  message,
+			Type:     stepDescription,
+			Contents: errorMessage,
+		}
+	case cucumber.StatusSkipped, cucumber.StatusUndefined, cucumber.StatusPending:
+		testCase.Skipped = &Skipped{Message: string(status)}
+	}
+
+	return testCase
+}
+
+// NewTestSuites converts the features of a cucumber report into junit test suites,
+// a feature becomes a test suite and a scenario becomes a test case.
+func NewTestSuites(name string, features []cucumber.Feature) TestSuites {
+	testSuites := TestSuites{Name: name}
+
+	var totalDuration time.Duration
+	for _, feature := range features {
+		testSuite := TestSuite{Name: feature.Name}
+
+		var duration time.Duration
+		for _, scenario := range feature.Scenarios() {
+			testCase := newTestCase(scenario)
+
+			testSuite.Tests++
+			if testCase.Failure != nil {
+				testSuite.Failures++
+			} else if testCase.Skipped != nil {
+				testSuite.Skipped++
+			}
+			duration += scenario.Duration()
+
+			testSuite.TestCases = append(testSuite.TestCases, testCase)
+		}
+		testSuite.Time = formatSeconds(duration)
+
+		testSuites.Tests += testSuite.Tests
+		testSuites.Failures += testSuite.Failures
+		testSuites.Skipped += testSuite.Skipped
+		totalDuration += duration
+
+		testSuites.TestSuites = append(testSuites.TestSuites, testSuite)
+	}
+	testSuites.Time = formatSeconds(totalDuration)
+
+	return testSuites
+}
+
+// WriteToFile writes the test suites as junit xml to the given path, creating its parent directory if needed.
+func WriteToFile(pth string, testSuites TestSuites) error {
+	content, err := xml.MarshalIndent(testSuites, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := pathutil.EnsureDirExist(filepath.Dir(pth)); err != nil {
+		return err
+	}
+
+	return fileutil.WriteBytesToFile(pth, append([]byte(xml.Header), content...))
+}
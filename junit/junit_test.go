@@ -0,0 +1,131 @@
+package junit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+)
+
+const testReport = `[
+{"uri":"features/login.feature","name":"Login","elements":[
+  {"type":"background","name":"","steps":[{"keyword":"Given ","name":"the app is running","result":{"status":"passed","duration":500000000}}]},
+  {"type":"scenario","name":"Valid login","line":6,"steps":[
+    {"keyword":"When ","name":"I log in","result":{"status":"passed","duration":1000000000}},
+    {"keyword":"Then ","name":"I see the home screen","result":{"status":"passed","duration":250000}}
+  ]},
+  {"type":"scenario","name":"Invalid login","line":10,"steps":[
+    {"keyword":"When ","name":"I log in with a wrong password","result":{"status":"passed","duration":1000000000}},
+    {"keyword":"Then ","name":"I see an error","result":{"status":"failed","duration":2000000000,"error_message":"element not found (RuntimeError)\n./features/step_definitions/login_steps.rb:5:in '/^I see an error$/'\nfeatures/login.feature:12:in 'Then I see an error'"}},
+    {"keyword":"And ","name":"I can retry","result":{"status":"skipped"}}
+  ]},
+  {"type":"scenario","name":"Forgotten password","line":14,"steps":[
+    {"keyword":"When ","name":"I reset my password","result":{"status":"undefined"}}
+  ]}
+]},
+{"uri":"features/search.feature","name":"Search","elements":[
+  {"type":"scenario","name":"Search by name","line":3,"before":[
+    {"match":{"location":"features/support/hooks.rb:3"},"result":{"status":"failed","duration":100000000,"error_message":""}}
+  ],"steps":[
+    {"keyword":"When ","name":"I search","result":{"status":"skipped"}}
+  ]},
+  {"type":"scenario","name":"Search by tag","line":8,"steps":[
+    {"keyword":"When ","name":"I search by tag","result":{"status":"pending","duration":0,"error_message":"TODO (Cucumber::Pending)"}}
+  ]},
+  {"type":"scenario","name":"Search history","line":12,"steps":[
+    {"keyword":"When ","name":"I open the history","result":{"status":"skipped"}}
+  ]}
+]}
+]`
+
+func TestNewTestSuites(t *testing.T) {
+	features, err := cucumber.ParseReport(strings.NewReader(testReport))
+	if err != nil {
+		t.Fatalf("ParseReport() error: %s", err)
+	}
+
+	testSuites := NewTestSuites("calabash-android", features)
+
+	if testSuites.Name != "calabash-android" || testSuites.Tests != 6 || testSuites.Failures != 2 || testSuites.Skipped != 3 || testSuites.Errors != 0 {
+		t.Errorf("test suites = %s: %d tests, %d failures, %d skipped, %d errors, want: 6 tests, 2 failures, 3 skipped, 0 errors",
+			testSuites.Name, testSuites.Tests, testSuites.Failures, testSuites.Skipped, testSuites.Errors)
+	}
+	if testSuites.Time != "4.600250" {
+		t.Errorf("test suites time = %s, want: 4.600250", testSuites.Time)
+	}
+	if len(testSuites.TestSuites) != 2 {
+		t.Fatalf("test suites: %d, want: 2", len(testSuites.TestSuites))
+	}
+
+	for i, want := range []struct {
+		name                     string
+		tests, failures, skipped int
+		time                     string
+	}{
+		{name: "Login", tests: 3, failures: 1, skipped: 1, time: "4.500250"},
+		{name: "Search", tests: 3, failures: 1, skipped: 2, time: "0.100000"},
+	} {
+		suite := testSuites.TestSuites[i]
+		if suite.Name != want.name || suite.Tests != want.tests || suite.Failures != want.failures || suite.Skipped != want.skipped || suite.Time != want.time {
+			t.Errorf("test suite %d = %s: %d tests, %d failures, %d skipped in %s, want: %+v",
+				i, suite.Name, suite.Tests, suite.Failures, suite.Skipped, suite.Time, want)
+		}
+	}
+
+	login := testSuites.TestSuites[0].TestCases
+	search := testSuites.TestSuites[1].TestCases
+
+	passed := login[0]
+	if passed.Failure != nil || passed.Skipped != nil || passed.ClassName != "Login" || passed.File != "features/login.feature:6" || passed.Time != "1.500250" {
+		t.Errorf("passed test case = %+v", passed)
+	}
+	// the background steps are part of the scenario's output
+	if want := "Given the app is running...passed\nWhen I log in...passed\nThen I see the home screen...passed"; passed.SystemOut != want {
+		t.Errorf("passed test case output:\n%s\nwant:\n%s", passed.SystemOut, want)
+	}
+
+	failed := login[1]
+	if failed.Failure == nil {
+		t.Fatalf("failed test case has no failure")
+	}
+	if failed.Failure.Message != "element not found (RuntimeError)" || failed.Failure.Type != "Then I see an error" {
+		t.Errorf("failure = %q of %q, want the first line of the error of the failing step", failed.Failure.Message, failed.Failure.Type)
+	}
+	if !strings.HasSuffix(failed.Failure.Contents, "features/login.feature:12:in 'Then I see an error'") {
+		t.Errorf("failure contents = %q, want the backtrace", failed.Failure.Contents)
+	}
+
+	// a failed hook without error message
+	hookFailed := search[0]
+	if hookFailed.Failure == nil || hookFailed.Failure.Message != "failed" || hookFailed.Failure.Type != "Before hook features/support/hooks.rb:3" {
+		t.Errorf("failed hook test case failure = %+v", hookFailed.Failure)
+	}
+
+	for _, tt := range []struct {
+		testCase TestCase
+		want     string
+	}{
+		{testCase: login[2], want: "undefined"},
+		{testCase: search[1], want: "pending"},
+		{testCase: search[2], want: "skipped"},
+	} {
+		if tt.testCase.Failure != nil || tt.testCase.Skipped == nil || tt.testCase.Skipped.Message != tt.want {
+			t.Errorf("test case %s = %+v, %+v, want skipped as %s", tt.testCase.Name, tt.testCase.Failure, tt.testCase.Skipped, tt.want)
+		}
+	}
+}
+
+func TestFormatSeconds(t *testing.T) {
+	for duration, want := range map[time.Duration]string{
+		0:                       "0.000000",
+		1500 * time.Millisecond: "1.500000",
+		250 * time.Microsecond:  "0.000250",
+		999:                     "0.000001",
+		2 * time.Minute:         "120.000000",
+	} {
+		if got := formatSeconds(duration); got != want {
+			t.Errorf("formatSeconds(%s) = %s, want: %s", duration, got, want)
+		}
+	}
+}
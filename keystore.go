@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/keystore"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+)
+
+// calabashSettingsFileName is the file in the work dir, which calabash-android reads the keystore configuration from.
+const calabashSettingsFileName = ".calabash_settings"
+
+// exitOnSignal exits the step after the calabash settings are restored on an interrupt, tests replace it.
+var exitOnSignal = os.Exit
+
+// keystoreConfig is the user provided keystore for signing the app and the test server.
+type keystoreConfig struct {
+	Path          string `json:"keystore_location"`
+	Password      string `json:"keystore_password"`
+	Alias         string `json:"keystore_alias"`
+	AliasPassword string `json:"keystore_alias_password"`
+}
+
+// keystorePathFromURL returns the local path of a keystore given by path or file:// URL.
+func keystorePathFromURL(keystoreURL string) (string, error) {
+	if !strings.Contains(keystoreURL, "://") {
+		return pathutil.AbsPath(keystoreURL)
+	}
+
+	u, err := url.Parse(keystoreURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported keystore url scheme: %s, use a path or a file:// url", u.Scheme)
+	}
+	return pathutil.AbsPath(u.Path)
+}
+
+func newKeystoreConfig(configs ConfigsModel) (keystoreConfig, error) {
+	pth, err := keystorePathFromURL(configs.KeystoreURL)
+	if err != nil {
+		return keystoreConfig{}, err
+	}
+
+	aliasPassword := configs.PrivateKeyPassword
+	if aliasPassword == "" {
+		aliasPassword = configs.KeystorePassword
+	}
+
+	return keystoreConfig{
+		Path:          pth,
+		Password:      configs.KeystorePassword,
+		Alias:         configs.KeystoreAlias,
+		AliasPassword: aliasPassword,
+	}, nil
+}
+
+// validate checks the keystore config, the keystore is opened with keytool if available.
+func (keystore keystoreConfig) validate() error {
+	if exist, err := pathutil.IsPathExists(keystore.Path); err != nil {
+		return fmt.Errorf("failed to check if keystore exists, error: %s", err)
+	} else if !exist {
+		return fmt.Errorf("keystore not exists at: %s", keystore.Path)
+	}
+	if keystore.Password == "" {
+		return errors.New("no keystore password specified")
+	}
+	if keystore.Alias == "" {
+		return errors.New("no keystore alias specified")
+	}
+
+	if _, err := exec.LookPath("keytool"); err != nil {
+		logger.Warnf("keytool not found, skipping keystore alias and password check")
+		return nil
+	}
+
+	cmd := command.New("keytool", "-list", "-keystore", keystore.Path, "-storepass", keystore.Password, "-alias", keystore.Alias)
+	if out, err := cmdExecutor.RunAndReturnTrimmedCombinedOutput(cmd); err != nil {
+		return fmt.Errorf("failed to open keystore with the given password and alias, output: %s", out)
+	}
+	return nil
+}
+
+// writeCalabashSettings writes the keystore config (with the passwords) into the work dir's .calabash_settings,
+// which calabash-android uses for signing both the app and the test server.
+// The returned function restores the original settings, they are restored on an interrupt or termination as well.
+func writeCalabashSettings(workDir string, keystore keystoreConfig) (func() error, error) {
+	settingsPth := filepath.Join(workDir, calabashSettingsFileName)
+
+	exist, err := pathutil.IsPathExists(settingsPth)
+	if err != nil {
+		return nil, err
+	}
+
+	originalContent := ""
+	if exist {
+		if originalContent, err = fileutil.ReadStringFromFile(settingsPth); err != nil {
+			return nil, err
+		}
+		logger.Warnf("Overriding the existing %s for the test run", settingsPth)
+	}
+
+	content, err := json.Marshal(keystore)
+	if err != nil {
+		return nil, err
+	}
+	if err := fileutil.WriteBytesToFile(settingsPth, content); err != nil {
+		return nil, err
+	}
+
+	return restoreOnSignal(func() error {
+		if exist {
+			return fileutil.WriteStringToFile(settingsPth, originalContent)
+		}
+		return command.RemoveFile(settingsPth)
+	}), nil
+}
+
+// restoreOnSignal runs restore if the step receives an interrupt or termination signal, then exits the step.
+// The returned function runs restore and stops watching the signals, restore runs only once.
+func restoreOnSignal(restore func() error) func() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	var once sync.Once
+	var restoreErr error
+	restoreOnce := func() error {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+			restoreErr = restore()
+		})
+		return restoreErr
+	}
+
+	go func() {
+		select {
+		case sig := <-signals:
+			if err := restoreOnce(); err != nil {
+				logger.Warnf("Failed to restore %s, error: %s", calabashSettingsFileName, err)
+			}
+			logger.Warnf("Received %s, %s restored", sig, calabashSettingsFileName)
+			exitOnSignal(1)
+		case <-done:
+		}
+	}()
+
+	return restoreOnce
+}
+
+// androidDebugKeystorePath returns $HOME/.android/debug.keystore, where the debug keystore is generated if none exists.
+func androidDebugKeystorePath() string {
+	return filepath.Join(pathutil.UserHomeDir(), ".android", "debug.keystore")
+}
+
+// findDebugKeystore returns the android or the xamarin debug keystore, or an empty path if none exists.
+// calabash-android resign falls back to these keystores if no keystore is configured.
+func findDebugKeystore() (string, error) {
+	homeDir := pathutil.UserHomeDir()
+
+	// $HOME/.android/debug.keystore
+	androidDebugKeystorePth := androidDebugKeystorePath()
+
+	if exist, err := pathutil.IsPathExists(androidDebugKeystorePth); err != nil {
+		return "", fmt.Errorf("failed to check if debug.keystore exists at (%s), error: %s", androidDebugKeystorePth, err)
+	} else if exist {
+		logger.Printf("using android debug keystore: %s", androidDebugKeystorePth)
+		return androidDebugKeystorePth, nil
+	}
+
+	logger.Warnf("android debug keystore not exist at: %s", androidDebugKeystorePth)
+
+	// $HOME/.local/share/Mono for Android/debug.keystore
+	xamarinDebugKeystorePth := filepath.Join(homeDir, ".local", "share", "Mono for Android", "debug.keystore")
+
+	logger.Printf("checking xamarin debug keystore at: %s", xamarinDebugKeystorePth)
+
+	if exist, err := pathutil.IsPathExists(xamarinDebugKeystorePth); err != nil {
+		return "", fmt.Errorf("failed to check if debug.keystore exists at (%s), error: %s", xamarinDebugKeystorePth, err)
+	} else if exist {
+		logger.Printf("using xamarin debug keystore: %s", xamarinDebugKeystorePth)
+		return xamarinDebugKeystorePth, nil
+	}
+
+	logger.Warnf("xamarin debug keystore not exist at: %s", xamarinDebugKeystorePth)
+	return "", nil
+}
+
+// ensureDebugKeystore returns the android or the xamarin debug keystore, or generates one if none exists,
+// keytool is only used if the native generation fails.
+func ensureDebugKeystore() (string, error) {
+	if pth, err := findDebugKeystore(); err != nil || pth != "" {
+		return pth, err
+	}
+
+	androidDebugKeystorePth := androidDebugKeystorePath()
+
+	logger.Printf("generating debug keystore")
+
+	if err := pathutil.EnsureDirExist(filepath.Dir(androidDebugKeystorePth)); err != nil {
+		return "", fmt.Errorf("failed to create dir for debug.keystore, error: %s", err)
+	}
+
+	if err := keystore.GenerateDebugKeystore(androidDebugKeystorePth); err != nil {
+		logger.Warnf("Failed to generate debug.keystore, error: %s", err)
+
+		if _, err := exec.LookPath("keytool"); err != nil {
+			return "", errors.New("failed to generate debug.keystore and keytool is not available as fallback")
+		}
+
+		logger.Printf("generating debug keystore with keytool")
+		if err := generateDebugKeystoreWithKeytool(androidDebugKeystorePth); err != nil {
+			return "", err
+		}
+	}
+
+	logger.Printf("using debug keystore: %s", androidDebugKeystorePth)
+	return androidDebugKeystorePth, nil
+}
+
+func generateDebugKeystoreWithKeytool(pth string) error {
+	// `keytool -genkey -v -keystore "#{debug_keystore}" -alias androiddebugkey -storepass android -keypass android -keyalg RSA -keysize 2048 -validity 10000 -dname "CN=Android Debug,O=Android,C=US"`
+	keytoolArgs := []string{"keytool", "-genkey", "-v", "-keystore", pth, "-alias", keystore.DebugAlias, "-storepass", keystore.DebugStorePassword, "-keypass", keystore.DebugKeyPassword, "-keyalg", "RSA", "-keysize", "2048", "-validity", "10000", "-dname", "CN=Android Debug,O=Android,C=US"}
+
+	cmd, err := command.NewFromSlice(keytoolArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to create command, error: %s", err)
+	}
+
+	logCommand(cmd)
+
+	if err := cmdExecutor.Run(cmd); err != nil {
+		return fmt.Errorf("failed to generate debug.keystore, error: %s", err)
+	}
+	return nil
+}
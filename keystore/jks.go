@@ -0,0 +1,228 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+// The debug keystore parameters used by the Android build tools.
+const (
+	DebugAlias         = "androiddebugkey"
+	DebugStorePassword = "android"
+	DebugKeyPassword   = "android"
+)
+
+const (
+	jksMagic              = 0xFEEDFEED
+	jksVersion            = 2
+	jksPrivateKeyEntryTag = 1
+
+	// the keystore integrity digest is salted with this phrase by the JDK
+	jksDigestSalt = "Mighty Aphrodite"
+
+	debugKeySize      = 2048
+	debugValidityDays = 10000
+)
+
+// oidJKSKeyProtector is the algorithm of the Sun proprietary private key protection used in JKS.
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     algorithmIdentifier
+	EncryptedData []byte
+}
+
+// passwordBytes returns the password as UTF-16BE, the way the JDK hashes passwords.
+func passwordBytes(password string) []byte {
+	chars := utf16.Encode([]rune(password))
+	b := make([]byte, len(chars)*2)
+	for i, c := range chars {
+		binary.BigEndian.PutUint16(b[i*2:], c)
+	}
+	return b
+}
+
+// protectKey encrypts the PKCS#8 encoded key with the JKS key protector:
+// salt | key XOR keystream | SHA1(password | key), where the keystream is chained SHA1(password | previous digest).
+func protectKey(plainKey []byte, password string) ([]byte, error) {
+	passwd := passwordBytes(password)
+
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, len(plainKey))
+	digest := salt
+	for offset := 0; offset < len(plainKey); offset += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, passwd...), digest...))
+		digest = sum[:]
+		for i := 0; i < sha1.Size && offset+i < len(plainKey); i++ {
+			encrypted[offset+i] = plainKey[offset+i] ^ digest[i]
+		}
+	}
+
+	check := sha1.Sum(append(append([]byte{}, passwd...), plainKey...))
+
+	protected := append(append(salt, encrypted...), check[:]...)
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: algorithmIdentifier{
+			Algorithm:  oidJKSKeyProtector,
+			Parameters: asn1.RawValue{Tag: asn1.TagNull},
+		},
+		EncryptedData: protected,
+	})
+}
+
+// writeUTF writes the string the way java.io.DataOutputStream.writeUTF does, for ASCII strings.
+func writeUTF(buf *bytes.Buffer, s string) error {
+	if len(s) > 0xFFFF {
+		return errors.New("string too long")
+	}
+	if err := binary.Write(buf, binary.BigEndian, uint16(len(s))); err != nil {
+		return err
+	}
+	_, err := buf.WriteString(s)
+	return err
+}
+
+// PrivateKeyEntry ...
+type PrivateKeyEntry struct {
+	Alias            string
+	PrivateKey       *rsa.PrivateKey
+	CertificateChain [][]byte
+	CreationDate     time.Time
+}
+
+// JKS encodes a Java KeyStore with the given private key entries.
+func JKS(entries []PrivateKeyEntry, storePassword, keyPassword string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	for _, v := range []uint32{jksMagic, jksVersion, uint32(len(entries))} {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range entries {
+		plainKey, err := x509.MarshalPKCS8PrivateKey(entry.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		protectedKey, err := protectKey(plainKey, keyPassword)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := binary.Write(&buf, binary.BigEndian, uint32(jksPrivateKeyEntryTag)); err != nil {
+			return nil, err
+		}
+		// keytool stores aliases in lower case
+		if err := writeUTF(&buf, strings.ToLower(entry.Alias)); err != nil {
+			return nil, err
+		}
+		if err := binary.Write(&buf, binary.BigEndian, entry.CreationDate.UnixNano()/int64(time.Millisecond)); err != nil {
+			return nil, err
+		}
+		if err := binary.Write(&buf, binary.BigEndian, uint32(len(protectedKey))); err != nil {
+			return nil, err
+		}
+		buf.Write(protectedKey)
+
+		if err := binary.Write(&buf, binary.BigEndian, uint32(len(entry.CertificateChain))); err != nil {
+			return nil, err
+		}
+		for _, cert := range entry.CertificateChain {
+			if err := writeUTF(&buf, "X.509"); err != nil {
+				return nil, err
+			}
+			if err := binary.Write(&buf, binary.BigEndian, uint32(len(cert))); err != nil {
+				return nil, err
+			}
+			buf.Write(cert)
+		}
+	}
+
+	digest := sha1.New()
+	digest.Write(passwordBytes(storePassword))
+	digest.Write([]byte(jksDigestSalt))
+	digest.Write(buf.Bytes())
+	buf.Write(digest.Sum(nil))
+
+	return buf.Bytes(), nil
+}
+
+// NewDebugKeyEntry creates an RSA 2048 key with a self-signed "CN=Android Debug,O=Android,C=US" certificate.
+func NewDebugKeyEntry() (PrivateKeyEntry, error) {
+	key, err := rsa.GenerateKey(rand.Reader, debugKeySize)
+	if err != nil {
+		return PrivateKeyEntry{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63))
+	if err != nil {
+		return PrivateKeyEntry{}, err
+	}
+
+	now := time.Now()
+	subject := pkix.Name{
+		CommonName:   "Android Debug",
+		Organization: []string{"Android"},
+		Country:      []string{"US"},
+	}
+	template := &x509.Certificate{
+		SerialNumber:       serial,
+		Subject:            subject,
+		Issuer:             subject,
+		NotBefore:          now,
+		NotAfter:           now.AddDate(0, 0, debugValidityDays),
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return PrivateKeyEntry{}, err
+	}
+
+	return PrivateKeyEntry{
+		Alias:            DebugAlias,
+		PrivateKey:       key,
+		CertificateChain: [][]byte{cert},
+		CreationDate:     now,
+	}, nil
+}
+
+// GenerateDebugKeystore writes a debug keystore to the given path,
+// equivalent to: keytool -genkey -keystore <pth> -alias androiddebugkey -storepass android -keypass android -keyalg RSA -keysize 2048 -validity 10000 -dname "CN=Android Debug,O=Android,C=US"
+func GenerateDebugKeystore(pth string) error {
+	entry, err := NewDebugKeyEntry()
+	if err != nil {
+		return err
+	}
+
+	content, err := JKS([]PrivateKeyEntry{entry}, DebugStorePassword, DebugKeyPassword)
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteBytesToFile(pth, content)
+}
@@ -0,0 +1,212 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+// jksEntry is a private key entry read back from a keystore.
+type jksEntry struct {
+	alias        string
+	creationDate int64
+	protectedKey []byte
+	certificates [][]byte
+}
+
+func readUTF(t *testing.T, reader io.Reader) string {
+	t.Helper()
+
+	var length uint16
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		t.Fatalf("failed to read string length: %s", err)
+	}
+	s := make([]byte, length)
+	if _, err := io.ReadFull(reader, s); err != nil {
+		t.Fatalf("failed to read string: %s", err)
+	}
+	return string(s)
+}
+
+func readBytes(t *testing.T, reader io.Reader) []byte {
+	t.Helper()
+
+	var length uint32
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		t.Fatalf("failed to read length: %s", err)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(reader, b); err != nil {
+		t.Fatalf("failed to read bytes: %s", err)
+	}
+	return b
+}
+
+// readJKS reads the private key entries of the keystore, and checks its integrity digest with the store password.
+func readJKS(t *testing.T, content []byte, storePassword string) []jksEntry {
+	t.Helper()
+
+	if len(content) < sha1.Size {
+		t.Fatalf("keystore too short: %d bytes", len(content))
+	}
+	body, storedDigest := content[:len(content)-sha1.Size], content[len(content)-sha1.Size:]
+
+	digest := sha1.New()
+	digest.Write(passwordBytes(storePassword))
+	digest.Write([]byte("Mighty Aphrodite"))
+	digest.Write(body)
+	if !bytes.Equal(digest.Sum(nil), storedDigest) {
+		t.Fatalf("keystore digest does not match with the store password")
+	}
+
+	reader := bytes.NewReader(body)
+	var header struct{ Magic, Version, Count uint32 }
+	if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+		t.Fatalf("failed to read header: %s", err)
+	}
+	if header.Magic != 0xFEEDFEED || header.Version != 2 {
+		t.Fatalf("header = %+v, want JKS version 2", header)
+	}
+
+	entries := []jksEntry{}
+	for i := uint32(0); i < header.Count; i++ {
+		var tag uint32
+		if err := binary.Read(reader, binary.BigEndian, &tag); err != nil || tag != 1 {
+			t.Fatalf("entry %d tag = %d (%v), want a private key entry", i, tag, err)
+		}
+
+		entry := jksEntry{alias: readUTF(t, reader)}
+		if err := binary.Read(reader, binary.BigEndian, &entry.creationDate); err != nil {
+			t.Fatalf("failed to read creation date: %s", err)
+		}
+		entry.protectedKey = readBytes(t, reader)
+
+		var certCount uint32
+		if err := binary.Read(reader, binary.BigEndian, &certCount); err != nil {
+			t.Fatalf("failed to read certificate count: %s", err)
+		}
+		for j := uint32(0); j < certCount; j++ {
+			if certType := readUTF(t, reader); certType != "X.509" {
+				t.Fatalf("certificate type = %s, want: X.509", certType)
+			}
+			entry.certificates = append(entry.certificates, readBytes(t, reader))
+		}
+		entries = append(entries, entry)
+	}
+
+	if reader.Len() != 0 {
+		t.Fatalf("%d bytes left after the entries", reader.Len())
+	}
+	return entries
+}
+
+// recoverKey decrypts the JKS key protector and checks its integrity digest with the key password.
+func recoverKey(t *testing.T, protectedKey []byte, keyPassword string) []byte {
+	t.Helper()
+
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(protectedKey, &info); err != nil || len(rest) != 0 {
+		t.Fatalf("invalid EncryptedPrivateKeyInfo: %v", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidJKSKeyProtector) {
+		t.Fatalf("key protector algorithm = %s, want: %s", info.Algorithm.Algorithm, oidJKSKeyProtector)
+	}
+
+	protected := info.EncryptedData
+	if len(protected) < 2*sha1.Size {
+		t.Fatalf("protected key too short: %d bytes", len(protected))
+	}
+	salt := protected[:sha1.Size]
+	encrypted := protected[sha1.Size : len(protected)-sha1.Size]
+	check := protected[len(protected)-sha1.Size:]
+
+	passwd := passwordBytes(keyPassword)
+	plainKey := make([]byte, len(encrypted))
+	digest := salt
+	for offset := 0; offset < len(encrypted); offset += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, passwd...), digest...))
+		digest = sum[:]
+		for i := 0; i < sha1.Size && offset+i < len(encrypted); i++ {
+			plainKey[offset+i] = encrypted[offset+i] ^ digest[i]
+		}
+	}
+
+	if sum := sha1.Sum(append(append([]byte{}, passwd...), plainKey...)); !bytes.Equal(sum[:], check) {
+		t.Fatalf("key protector digest does not match with the key password")
+	}
+	return plainKey
+}
+
+func TestJKS(t *testing.T) {
+	entry, err := NewDebugKeyEntry()
+	if err != nil {
+		t.Fatalf("NewDebugKeyEntry() error: %s", err)
+	}
+	entry.Alias = "AndroidDebugKey"
+
+	content, err := JKS([]PrivateKeyEntry{entry}, "store-pass", "key-pass")
+	if err != nil {
+		t.Fatalf("JKS() error: %s", err)
+	}
+
+	entries := readJKS(t, content, "store-pass")
+	if len(entries) != 1 {
+		t.Fatalf("entries: %d, want: 1", len(entries))
+	}
+	got := entries[0]
+
+	if got.alias != "androiddebugkey" {
+		t.Errorf("alias = %s, want it in lower case", got.alias)
+	}
+	if want := entry.CreationDate.UnixNano() / 1e6; got.creationDate != want {
+		t.Errorf("creation date = %d, want: %d", got.creationDate, want)
+	}
+	if len(got.certificates) != 1 || !bytes.Equal(got.certificates[0], entry.CertificateChain[0]) {
+		t.Errorf("certificate chain does not match")
+	}
+
+	wantKey, err := x509.MarshalPKCS8PrivateKey(entry.PrivateKey)
+	if err != nil {
+		t.Fatalf("failed to encode the key: %s", err)
+	}
+	if plainKey := recoverKey(t, got.protectedKey, "key-pass"); !bytes.Equal(plainKey, wantKey) {
+		t.Errorf("decrypted key does not match the original PKCS#8 key")
+	}
+}
+
+func TestGenerateDebugKeystore(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "debug.keystore")
+	if err := GenerateDebugKeystore(pth); err != nil {
+		t.Fatalf("GenerateDebugKeystore() error: %s", err)
+	}
+
+	content, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		t.Fatalf("failed to read the keystore: %s", err)
+	}
+
+	entries := readJKS(t, content, DebugStorePassword)
+	if len(entries) != 1 || entries[0].alias != DebugAlias {
+		t.Fatalf("entries = %+v, want the %s entry", entries, DebugAlias)
+	}
+
+	plainKey := recoverKey(t, entries[0].protectedKey, DebugKeyPassword)
+	if _, err := x509.ParsePKCS8PrivateKey(plainKey); err != nil {
+		t.Errorf("decrypted key is not a PKCS#8 key: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(entries[0].certificates[0])
+	if err != nil {
+		t.Fatalf("invalid certificate: %s", err)
+	}
+	if cert.Subject.String() != "CN=Android Debug,O=Android,C=US" {
+		t.Errorf("certificate subject = %s", cert.Subject)
+	}
+}
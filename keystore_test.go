@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func TestEnsureDebugKeystoreFallbackOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		want     string
+	}{
+		{
+			name:     "android debug keystore first",
+			existing: []string{".android/debug.keystore", ".local/share/Mono for Android/debug.keystore"},
+			want:     ".android/debug.keystore",
+		},
+		{
+			name:     "xamarin debug keystore",
+			existing: []string{".local/share/Mono for Android/debug.keystore"},
+			want:     ".local/share/Mono for Android/debug.keystore",
+		},
+		{
+			name: "generated debug keystore",
+			want: ".android/debug.keystore",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := withFakeExecutor(t)
+
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+
+			for _, pth := range tt.existing {
+				writeTestFile(t, filepath.Join(home, pth), "keystore")
+			}
+
+			got, err := ensureDebugKeystore()
+			if err != nil {
+				t.Fatalf("ensureDebugKeystore() error: %s", err)
+			}
+			if want := filepath.Join(home, tt.want); got != want {
+				t.Errorf("keystore = %s, want: %s", got, want)
+			}
+
+			// the keystore is generated natively, keytool is only a fallback
+			requireCommands(t, fake)
+
+			if len(tt.existing) == 0 {
+				content, err := fileutil.ReadBytesFromFile(got)
+				if err != nil {
+					t.Fatalf("failed to read generated keystore: %s", err)
+				}
+				if !bytes.HasPrefix(content, []byte{0xFE, 0xED, 0xFE, 0xED}) {
+					t.Errorf("generated keystore is not a JKS keystore")
+				}
+			}
+		})
+	}
+}
+
+func TestPrepareConfiguredKeystore(t *testing.T) {
+	withFakeExecutor(t)
+
+	workDir := t.TempDir()
+	keystorePth := filepath.Join(t.TempDir(), "release.keystore")
+	writeTestFile(t, keystorePth, "keystore")
+
+	settingsPth := filepath.Join(workDir, calabashSettingsFileName)
+	writeTestFile(t, settingsPth, `{"keystore_location":"original"}`)
+
+	configs := ConfigsModel{
+		KeystoreURL:      "file://" + keystorePth,
+		KeystorePassword: "store-pass",
+		KeystoreAlias:    "release",
+	}
+
+	restore, err := prepareKeystore(configs, workDir, nil)
+	if err != nil {
+		t.Fatalf("prepareKeystore() error: %s", err)
+	}
+
+	content, err := fileutil.ReadBytesFromFile(settingsPth)
+	if err != nil {
+		t.Fatalf("failed to read calabash settings: %s", err)
+	}
+	var settings keystoreConfig
+	if err := json.Unmarshal(content, &settings); err != nil {
+		t.Fatalf("invalid calabash settings: %s", err)
+	}
+	want := keystoreConfig{Path: keystorePth, Password: "store-pass", Alias: "release", AliasPassword: "store-pass"}
+	if settings != want {
+		t.Errorf("settings = %+v, want: %+v", settings, want)
+	}
+
+	if err := restore(); err != nil {
+		t.Fatalf("restore() error: %s", err)
+	}
+	if original, err := fileutil.ReadStringFromFile(settingsPth); err != nil || original != `{"keystore_location":"original"}` {
+		t.Errorf("settings not restored: %s, error: %v", original, err)
+	}
+}
+
+func TestPrepareMissingKeystore(t *testing.T) {
+	withFakeExecutor(t)
+
+	workDir := t.TempDir()
+	configs := ConfigsModel{
+		KeystoreURL:      filepath.Join(workDir, "missing.keystore"),
+		KeystorePassword: "store-pass",
+		KeystoreAlias:    "release",
+	}
+
+	if _, err := prepareKeystore(configs, workDir, nil); err == nil {
+		t.Fatalf("prepareKeystore() succeeded with a missing keystore")
+	}
+
+	if exist, err := pathutil.IsPathExists(filepath.Join(workDir, calabashSettingsFileName)); err != nil || exist {
+		t.Errorf("calabash settings written for an invalid keystore")
+	}
+}
+
+func TestCalabashSettingsRestoredOnSignal(t *testing.T) {
+	exited := make(chan int, 1)
+	originalExit := exitOnSignal
+	exitOnSignal = func(code int) { exited <- code }
+	t.Cleanup(func() { exitOnSignal = originalExit })
+
+	workDir := t.TempDir()
+	restore, err := writeCalabashSettings(workDir, keystoreConfig{Path: "release.keystore", Password: "store-pass", Alias: "release"})
+	if err != nil {
+		t.Fatalf("writeCalabashSettings() error: %s", err)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("failed to send SIGTERM: %s", err)
+	}
+
+	select {
+	case code := <-exited:
+		if code == 0 {
+			t.Errorf("exit code = 0, want a failure")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the step did not exit on SIGTERM")
+	}
+
+	if exist, err := pathutil.IsPathExists(filepath.Join(workDir, calabashSettingsFileName)); err != nil || exist {
+		t.Errorf("calabash settings with the passwords left in the work dir")
+	}
+
+	// the settings are restored only once, restore after the signal is a no-op
+	writeTestFile(t, filepath.Join(workDir, calabashSettingsFileName), "{}")
+	if err := restore(); err != nil {
+		t.Fatalf("restore() error: %s", err)
+	}
+	if exist, err := pathutil.IsPathExists(filepath.Join(workDir, calabashSettingsFileName)); err != nil || !exist {
+		t.Errorf("restore() ran again after the signal")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/adb"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logcat"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+)
+
+// logcatTarget is a device of the run, with its logcat file and the report of the tests run on it.
+type logcatTarget struct {
+	serial    string
+	pth       string
+	reportPth string
+}
+
+// logcatCapture streams the logcat of a device into a file during the test run.
+type logcatCapture struct {
+	logcatTarget
+
+	cmd  *command.Model
+	file *os.File
+}
+
+// logcatCommands returns the commands, which clear the logcat of the device and stream it in threadtime format.
+func logcatCommands(adbTool *adb.Model, serial string) (*command.Model, *command.Model) {
+	deviceAdb := adbTool.WithSerial(serial)
+	return deviceAdb.Command("logcat", "-c"), deviceAdb.Command("logcat", "-v", "threadtime")
+}
+
+// startLogcat clears the logcat of the device, then starts streaming it into the file.
+func startLogcat(adbTool *adb.Model, target logcatTarget) (*logcatCapture, error) {
+	clearCmd, captureCmd := logcatCommands(adbTool, target.serial)
+
+	logCommand(clearCmd)
+	if out, err := cmdExecutor.RunAndReturnTrimmedCombinedOutput(clearCmd); err != nil {
+		// the capture is still useful with the earlier logs
+		logger.Warnf("Failed to clear logcat, output: %s, error: %s", out, err)
+	}
+
+	if err := pathutil.EnsureDirExist(filepath.Dir(target.pth)); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(target.pth)
+	if err != nil {
+		return nil, err
+	}
+
+	captureCmd.SetStdout(file).SetStderr(file)
+
+	logCommand(captureCmd)
+	if err := cmdExecutor.Start(captureCmd); err != nil {
+		if cerr := file.Close(); cerr != nil {
+			logger.Warnf("Failed to close %s, error: %s", target.pth, cerr)
+		}
+		return nil, fmt.Errorf("failed to start logcat, error: %s", err)
+	}
+
+	return &logcatCapture{logcatTarget: target, cmd: captureCmd, file: file}, nil
+}
+
+// stop stops streaming the logcat.
+func (capture *logcatCapture) stop() error {
+	if err := cmdExecutor.Signal(capture.cmd, syscall.SIGTERM); err != nil {
+		logger.Warnf("Failed to stop logcat of %s, error: %s", capture.serial, err)
+	}
+	if err := cmdExecutor.Wait(capture.cmd); err != nil {
+		// the terminated logcat exits with the signal
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.Exited() {
+			logger.Warnf("logcat of %s failed, error: %s", capture.serial, err)
+		}
+	}
+	return capture.file.Close()
+}
+
+// filterAppLogcat writes the lines of the app into a new file next to the captured logcat, and returns its path.
+func filterAppLogcat(pth, packageName string) (string, error) {
+	lines, err := logcat.ParseFile(pth)
+	if err != nil {
+		return "", err
+	}
+
+	var filtered strings.Builder
+	for _, line := range logcat.FilterApp(lines, packageName) {
+		filtered.WriteString(line.Raw + "\n")
+	}
+
+	filteredPth := strings.TrimSuffix(pth, filepath.Ext(pth)) + "_" + packageName + filepath.Ext(pth)
+	if err := fileutil.WriteStringToFile(filteredPth, filtered.String()); err != nil {
+		return "", err
+	}
+	return filteredPth, nil
+}
+
+// captureLogcat streams the logcat of the devices during the run, it returns the stopped captures.
+func captureLogcat(adbTool *adb.Model, targets []logcatTarget, run func() error) ([]*logcatCapture, error) {
+	captures := []*logcatCapture{}
+	for _, target := range targets {
+		capture, err := startLogcat(adbTool, target)
+		if err != nil {
+			logger.Warnf("Failed to capture logcat of %s, error: %s", target.serial, err)
+			continue
+		}
+		captures = append(captures, capture)
+	}
+
+	runErr := run()
+
+	stopped := []*logcatCapture{}
+	for _, capture := range captures {
+		if err := capture.stop(); err != nil {
+			logger.Warnf("Failed to write logcat of %s, error: %s", capture.serial, err)
+			continue
+		}
+		stopped = append(stopped, capture)
+	}
+
+	return stopped, runErr
+}
@@ -0,0 +1,134 @@
+package logcat
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Crash kinds.
+const (
+	// KindCrash is an uncaught java exception, logged with FATAL EXCEPTION.
+	KindCrash = "crash"
+	// KindNativeCrash is a native crash, logged as a tombstone.
+	KindNativeCrash = "native crash"
+	// KindANR is an Application Not Responding error.
+	KindANR = "ANR"
+)
+
+// maxANRDetails limits the ANR details, the ActivityManager logs the whole CPU usage after the reason.
+const maxANRDetails = 10
+
+// Crash is a crash or ANR found in the logcat.
+type Crash struct {
+	Kind    string
+	Time    string
+	Process string
+	PID     int
+	// Message is the exception, the signal or the reason of the ANR
+	Message    string
+	StackTrace []string
+}
+
+var (
+	fatalProcessPattern  = regexp.MustCompile(`^Process: ([^,\s]+), PID: (\d+)`)
+	tombstoneMarker      = "*** *** *** *** *** *** *** *** *** *** *** *** *** *** *** ***"
+	tombstoneProcess     = regexp.MustCompile(`^pid: (\d+), tid: \d+, name: .*>>> (\S+) <<<`)
+	tombstoneFrame       = regexp.MustCompile(`^\s*#\d+ pc `)
+	anrPattern           = regexp.MustCompile(`^ANR in (\S+)`)
+	anrPIDPattern        = regexp.MustCompile(`^PID: (\d+)`)
+	anrReasonPattern     = regexp.MustCompile(`^Reason: (.*)`)
+	anrDetailsEndPattern = regexp.MustCompile(`^CPU usage`)
+)
+
+// block returns the lines following the first line, logged with the same tag by the same process.
+func block(lines []Line, first int) []Line {
+	end := first + 1
+	for end < len(lines) && lines[end].PID == lines[first].PID && lines[end].Tag == lines[first].Tag {
+		end++
+	}
+	return lines[first+1 : end]
+}
+
+func javaCrash(first Line, following []Line) Crash {
+	crash := Crash{Kind: KindCrash, Time: first.Time, PID: first.PID}
+	for _, line := range following {
+		if match := fatalProcessPattern.FindStringSubmatch(line.Message); match != nil {
+			crash.Process = match[1]
+			if pid, err := strconv.Atoi(match[2]); err == nil {
+				crash.PID = pid
+			}
+			continue
+		}
+		if crash.Message == "" {
+			crash.Message = line.Message
+			continue
+		}
+		crash.StackTrace = append(crash.StackTrace, strings.TrimSpace(line.Message))
+	}
+	return crash
+}
+
+func nativeCrash(first Line, following []Line) Crash {
+	crash := Crash{Kind: KindNativeCrash, Time: first.Time}
+	for _, line := range following {
+		switch {
+		case line.Message == tombstoneMarker:
+			// the next tombstone
+			return crash
+		case tombstoneProcess.MatchString(line.Message):
+			match := tombstoneProcess.FindStringSubmatch(line.Message)
+			crash.Process = match[2]
+			if pid, err := strconv.Atoi(match[1]); err == nil {
+				crash.PID = pid
+			}
+		case strings.HasPrefix(line.Message, "signal ") && crash.Message == "":
+			crash.Message = line.Message
+		case tombstoneFrame.MatchString(line.Message):
+			crash.StackTrace = append(crash.StackTrace, strings.TrimSpace(line.Message))
+		}
+	}
+	return crash
+}
+
+func anr(first Line, following []Line, process string) Crash {
+	crash := Crash{Kind: KindANR, Time: first.Time, Process: process}
+	for _, line := range following {
+		if anrDetailsEndPattern.MatchString(line.Message) || len(crash.StackTrace) >= maxANRDetails {
+			break
+		}
+		if match := anrPIDPattern.FindStringSubmatch(line.Message); match != nil {
+			if pid, err := strconv.Atoi(match[1]); err == nil {
+				crash.PID = pid
+			}
+		}
+		if match := anrReasonPattern.FindStringSubmatch(line.Message); match != nil {
+			crash.Message = match[1]
+		}
+		crash.StackTrace = append(crash.StackTrace, strings.TrimSpace(line.Message))
+	}
+	return crash
+}
+
+// FindCrashes returns the crashes and ANRs of the app's processes, or of every process if no package name is given.
+func FindCrashes(lines []Line, packageName string) []Crash {
+	crashes := []Crash{}
+	for i, line := range lines {
+		var crash Crash
+		switch {
+		case line.Tag == "AndroidRuntime" && strings.HasPrefix(line.Message, "FATAL EXCEPTION"):
+			crash = javaCrash(line, block(lines, i))
+		case line.Tag == "DEBUG" && line.Message == tombstoneMarker:
+			crash = nativeCrash(line, block(lines, i))
+		case line.Tag == "ActivityManager" && anrPattern.MatchString(line.Message):
+			crash = anr(line, block(lines, i), anrPattern.FindStringSubmatch(line.Message)[1])
+		default:
+			continue
+		}
+
+		if packageName == "" || isAppProcess(crash.Process, packageName) {
+			crashes = append(crashes, crash)
+		}
+	}
+	return crashes
+}
@@ -0,0 +1,155 @@
+package logcat
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Line is a logcat line in threadtime format, like:
+// 10-17 04:15:28.286  1234  1250 E AndroidRuntime: FATAL EXCEPTION: main
+type Line struct {
+	Raw     string
+	Time    string
+	PID     int
+	TID     int
+	Level   string
+	Tag     string
+	Message string
+}
+
+// timeLayout is the time format of the threadtime lines, without year and time zone.
+const timeLayout = "01-02 15:04:05.000"
+
+var threadtimePattern = regexp.MustCompile(`^(\d\d-\d\d \d\d:\d\d:\d\d\.\d+)\s+(\d+)\s+(\d+)\s+([VDIWEFA])\s+(.*?)\s*: (.*)$`)
+
+// ParseLine parses a threadtime formatted line, the header lines (like: --------- beginning of main) are not log lines.
+func ParseLine(raw string) (Line, bool) {
+	match := threadtimePattern.FindStringSubmatch(raw)
+	if match == nil {
+		return Line{}, false
+	}
+
+	pid, err := strconv.Atoi(match[2])
+	if err != nil {
+		return Line{}, false
+	}
+	tid, err := strconv.Atoi(match[3])
+	if err != nil {
+		return Line{}, false
+	}
+
+	return Line{
+		Raw:     raw,
+		Time:    match[1],
+		PID:     pid,
+		TID:     tid,
+		Level:   match[4],
+		Tag:     match[5],
+		Message: match[6],
+	}, true
+}
+
+// Elapsed returns the time elapsed between two times of the logcat.
+func Elapsed(from, to string) (time.Duration, error) {
+	fromTime, err := time.Parse(timeLayout, from)
+	if err != nil {
+		return 0, err
+	}
+	toTime, err := time.Parse(timeLayout, to)
+	if err != nil {
+		return 0, err
+	}
+	return toTime.Sub(fromTime), nil
+}
+
+// Parse returns the log lines of the logcat output.
+func Parse(reader io.Reader) ([]Line, error) {
+	lines := []Line{}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line, ok := ParseLine(scanner.Text()); ok {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// ParseFile returns the log lines of the logcat file.
+func ParseFile(pth string) ([]Line, error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := Parse(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return lines, err
+}
+
+var processStartPatterns = []*regexp.Regexp{
+	// Start proc 12345:com.example.app/u0a123 for activity {...}
+	regexp.MustCompile(`^Start proc (\d+):([^/\s]+)`),
+	// Start proc com.example.app for activity com.example.app/.MainActivity: pid=12345 uid=10123 gids={...}
+	regexp.MustCompile(`^Start proc ([^\s]+) .*pid=(\d+)`),
+}
+
+// processStart returns the pid and the process name of an ActivityManager process start line.
+func processStart(line Line) (int, string, bool) {
+	if line.Tag != "ActivityManager" {
+		return 0, "", false
+	}
+
+	for i, pattern := range processStartPatterns {
+		match := pattern.FindStringSubmatch(line.Message)
+		if match == nil {
+			continue
+		}
+
+		pidIdx, nameIdx := 1, 2
+		if i == 1 {
+			pidIdx, nameIdx = 2, 1
+		}
+		pid, err := strconv.Atoi(match[pidIdx])
+		if err != nil {
+			return 0, "", false
+		}
+		return pid, match[nameIdx], true
+	}
+	return 0, "", false
+}
+
+// isAppProcess returns whether the process belongs to the app, the app may have more processes, like: com.example.app:remote
+func isAppProcess(processName, packageName string) bool {
+	return processName == packageName || strings.HasPrefix(processName, packageName+":")
+}
+
+// FilterApp returns the lines of the app's processes, and the lines of other processes mentioning the app,
+// like the process start and ANR lines of the ActivityManager.
+func FilterApp(lines []Line, packageName string) []Line {
+	pids := map[int]bool{}
+	filtered := []Line{}
+	for _, line := range lines {
+		// a pid is reused only after the process died, the app processes are collected in order
+		if pid, name, ok := processStart(line); ok {
+			if isAppProcess(name, packageName) {
+				pids[pid] = true
+			} else {
+				delete(pids, pid)
+			}
+		}
+
+		if pids[line.PID] || strings.Contains(line.Message, packageName) {
+			filtered = append(filtered, line)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,239 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/colorstring"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Levels of the log events.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelDone  = "done"
+	LevelWarn  = "warn"
+	LevelError = "error"
+	// LevelOutput is the level of the child process output.
+	LevelOutput = "output"
+)
+
+// Fields are the structured data of an event, like command args, exit codes or durations.
+type Fields map[string]interface{}
+
+// Event is a log event, printed as a colored line in text format and as a JSON object in json format.
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	Stage     string    `json:"stage,omitempty"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+	// Source is the child process, which printed the message.
+	Source string `json:"source,omitempty"`
+	Fields Fields `json:"fields,omitempty"`
+
+	// header events are section titles, printed in blue in text format
+	header bool
+}
+
+// String returns the message colored by the level, the way go-utils log prints it.
+func (event Event) String() string {
+	if event.header {
+		return colorstring.Blue(event.Message)
+	}
+
+	switch event.Level {
+	case LevelDone:
+		return colorstring.Green(event.Message)
+	case LevelWarn:
+		return colorstring.Yellow(event.Message)
+	case LevelError:
+		return colorstring.Red(event.Message)
+	default:
+		return event.Message
+	}
+}
+
+// JSON returns the event as a single line JSON object.
+func (event Event) JSON() string {
+	b, err := json.Marshal(event)
+	if err != nil {
+		// the fields can not be marshalled, keep the message
+		event.Fields = Fields{"marshal_error": err.Error()}
+		if b, err = json.Marshal(event); err != nil {
+			return ""
+		}
+	}
+	return string(b) + "\n"
+}
+
+var (
+	mutex   sync.Mutex
+	format  = FormatText
+	stage   string
+	printer log.Logger = log.NewRawLogger(os.Stdout)
+)
+
+// SetFormat sets the log format, text or json.
+func SetFormat(logFormat string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	switch logFormat {
+	case FormatText:
+		printer = log.NewRawLogger(os.Stdout)
+	case FormatJSON:
+		printer = log.NewJSONLoger(os.Stdout)
+	default:
+		return fmt.Errorf("invalid log format: %s, available: %s, %s", logFormat, FormatText, FormatJSON)
+	}
+	format = logFormat
+	return nil
+}
+
+// IsJSON returns whether the events are printed as JSON.
+func IsJSON() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return format == FormatJSON
+}
+
+// SetStage sets the stage of the following events.
+func SetStage(name string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	stage = name
+}
+
+func emit(event Event) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// debug events carry structured data only, text logs stay as they were
+	if event.Level == LevelDebug && format != FormatJSON {
+		return
+	}
+
+	event.Timestamp = time.Now()
+	event.Stage = stage
+	printer.Print(event)
+}
+
+// Entry is an event builder with fields.
+type Entry struct {
+	fields Fields
+}
+
+// With returns an entry, which prints events with the given fields.
+func With(fields Fields) Entry {
+	return Entry{fields: fields}
+}
+
+func (entry Entry) printf(level, format string, v ...interface{}) {
+	emit(Event{Level: level, Message: fmt.Sprintf(format, v...), Fields: entry.fields})
+}
+
+// Infof prints a section title.
+func (entry Entry) Infof(format string, v ...interface{}) {
+	emit(Event{Level: LevelInfo, Message: fmt.Sprintf(format, v...), Fields: entry.fields, header: true})
+}
+
+// Debugf prints an event in json format only.
+func (entry Entry) Debugf(format string, v ...interface{}) { entry.printf(LevelDebug, format, v...) }
+
+// Printf ...
+func (entry Entry) Printf(format string, v ...interface{}) { entry.printf(LevelInfo, format, v...) }
+
+// Donef ...
+func (entry Entry) Donef(format string, v ...interface{}) { entry.printf(LevelDone, format, v...) }
+
+// Warnf ...
+func (entry Entry) Warnf(format string, v ...interface{}) { entry.printf(LevelWarn, format, v...) }
+
+// Errorf ...
+func (entry Entry) Errorf(format string, v ...interface{}) { entry.printf(LevelError, format, v...) }
+
+// Printf ...
+func Printf(format string, v ...interface{}) { Entry{}.printf(LevelInfo, format, v...) }
+
+// Infof prints a section title.
+func Infof(format string, v ...interface{}) { Entry{}.Infof(format, v...) }
+
+// Donef ...
+func Donef(format string, v ...interface{}) { Entry{}.printf(LevelDone, format, v...) }
+
+// Warnf ...
+func Warnf(format string, v ...interface{}) { Entry{}.printf(LevelWarn, format, v...) }
+
+// Errorf ...
+func Errorf(format string, v ...interface{}) { Entry{}.printf(LevelError, format, v...) }
+
+// Println prints an empty line in text format, the json format has no empty events.
+func Println() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if format == FormatText {
+		fmt.Println()
+	}
+}
+
+// OutputWriter forwards the output of a child process:
+// as it is in text format, line by line as output events in json format.
+type OutputWriter struct {
+	source string
+	fields Fields
+	text   io.Writer
+	buffer bytes.Buffer
+}
+
+// NewOutputWriter returns the writer of a child process output, with the name of the child process as source.
+// In text format the output is written to the text writer.
+func NewOutputWriter(source string, text io.Writer, fields Fields) *OutputWriter {
+	return &OutputWriter{source: source, text: text, fields: fields}
+}
+
+func (w *OutputWriter) Write(p []byte) (int, error) {
+	if !IsJSON() {
+		return w.text.Write(p)
+	}
+
+	w.buffer.Write(p)
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// keep the unterminated line for the next write
+			w.buffer.Reset()
+			w.buffer.WriteString(line)
+			break
+		}
+		w.printLine(line[:len(line)-1])
+	}
+	return len(p), nil
+}
+
+func (w *OutputWriter) printLine(line string) {
+	emit(Event{Level: LevelOutput, Message: line, Source: w.source, Fields: w.fields})
+}
+
+// Flush prints the unterminated last line of the output.
+func (w *OutputWriter) Flush() error {
+	if w.buffer.Len() > 0 {
+		w.printLine(w.buffer.String())
+		w.buffer.Reset()
+	}
+	return nil
+}
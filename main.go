@@ -5,16 +5,19 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/bitrise-io/go-utils/command"
-	"github.com/bitrise-io/go-utils/command/rubycommand"
 	"github.com/bitrise-io/go-utils/fileutil"
-	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/pathutil"
-	"github.com/hashicorp/go-version"
-	shellquote "github.com/kballard/go-shellquote"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/adb"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/apkinfo"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/junit"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/screenshot"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/sdk"
 )
 
 // ConfigsModel ...
@@ -24,34 +27,121 @@ type ConfigsModel struct {
 	ApkPath     string
 	Options     string
 
-	AndroidHome string
+	AndroidHome       string
+	BuildToolsVersion string
+	DeviceSerial      string
+	ParallelRun       string
+	RetryFailedCount  string
+	RunTimeout        string
+	InactivityTimeout string
+	CaptureLogcat     string
+	LogcatAppOnly     string
+	FailOnCrash       string
+	BootTimeout       string
 
 	CalabashAndroidVersion string
+
+	KeystoreURL        string
+	KeystorePassword   string
+	KeystoreAlias      string
+	PrivateKeyPassword string
+
+	JUnitReportPath    string
+	MaxPrintedFailures string
+	TestResultOutputs  string
+	QuarantineFile     string
+
+	DryRun         string
+	DryRunPlanPath string
+
+	LogFormat string
 }
 
 func createConfigsModelFromEnvs() ConfigsModel {
+	androidHome := os.Getenv("android_home")
+	if androidHome == "" {
+		androidHome = os.Getenv("ANDROID_SDK_ROOT")
+	}
+
 	return ConfigsModel{
 		WorkDir:     os.Getenv("work_dir"),
 		GemFilePath: os.Getenv("gem_file_path"),
 		ApkPath:     os.Getenv("apk_path"),
 		Options:     os.Getenv("additional_options"),
 
-		AndroidHome: os.Getenv("android_home"),
+		AndroidHome:       androidHome,
+		BuildToolsVersion: os.Getenv("build_tools_version"),
+		DeviceSerial:      os.Getenv("device_serial"),
+		ParallelRun:       os.Getenv("parallel_run"),
+		RetryFailedCount:  os.Getenv("retry_failed_count"),
+		RunTimeout:        os.Getenv("run_timeout"),
+		InactivityTimeout: os.Getenv("inactivity_timeout"),
+		CaptureLogcat:     os.Getenv("capture_logcat"),
+		LogcatAppOnly:     os.Getenv("logcat_app_only"),
+		FailOnCrash:       os.Getenv("fail_on_crash"),
+		BootTimeout:       os.Getenv("boot_timeout"),
 
 		CalabashAndroidVersion: os.Getenv("calabash_android_version"),
+
+		KeystoreURL:        os.Getenv("keystore_url"),
+		KeystorePassword:   os.Getenv("keystore_password"),
+		KeystoreAlias:      os.Getenv("keystore_alias"),
+		PrivateKeyPassword: os.Getenv("private_key_password"),
+
+		JUnitReportPath:    os.Getenv("junit_report_path"),
+		MaxPrintedFailures: os.Getenv("max_printed_failures"),
+		TestResultOutputs:  os.Getenv("test_result_outputs"),
+		QuarantineFile:     os.Getenv("quarantine_file"),
+
+		DryRun:         os.Getenv("dry_run"),
+		DryRunPlanPath: os.Getenv("dry_run_plan_path"),
+
+		LogFormat: os.Getenv("log_format"),
 	}
 }
 
-func (configs ConfigsModel) print() {
-	log.Infof("Configs:")
-	log.Printf("- WorkDir: %s", configs.WorkDir)
-	log.Printf("- GemFilePath: %s", configs.GemFilePath)
-	log.Printf("- ApkPath: %s", configs.ApkPath)
-	log.Printf("- Options: %s", configs.Options)
-
-	log.Printf("- AndroidHome: %s", configs.AndroidHome)
+func secretValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "***"
+}
 
-	log.Printf("- CalabashAndroidVersion: %s", configs.CalabashAndroidVersion)
+func (configs ConfigsModel) print() {
+	logger.Infof("Configs:")
+	logger.Printf("- WorkDir: %s", configs.WorkDir)
+	logger.Printf("- GemFilePath: %s", configs.GemFilePath)
+	logger.Printf("- ApkPath: %s", configs.ApkPath)
+	logger.Printf("- Options: %s", configs.Options)
+
+	logger.Printf("- AndroidHome: %s", configs.AndroidHome)
+	logger.Printf("- BuildToolsVersion: %s", configs.BuildToolsVersion)
+	logger.Printf("- DeviceSerial: %s", configs.DeviceSerial)
+	logger.Printf("- ParallelRun: %s", configs.ParallelRun)
+	logger.Printf("- RetryFailedCount: %s", configs.RetryFailedCount)
+	logger.Printf("- RunTimeout: %s", configs.RunTimeout)
+	logger.Printf("- InactivityTimeout: %s", configs.InactivityTimeout)
+	logger.Printf("- CaptureLogcat: %s", configs.CaptureLogcat)
+	logger.Printf("- LogcatAppOnly: %s", configs.LogcatAppOnly)
+	logger.Printf("- FailOnCrash: %s", configs.FailOnCrash)
+	logger.Printf("- BootTimeout: %s", configs.BootTimeout)
+
+	logger.Printf("- CalabashAndroidVersion: %s", configs.CalabashAndroidVersion)
+
+	logger.Printf("- KeystoreURL: %s", configs.KeystoreURL)
+	logger.Printf("- KeystorePassword: %s", secretValue(configs.KeystorePassword))
+	logger.Printf("- KeystoreAlias: %s", configs.KeystoreAlias)
+	logger.Printf("- PrivateKeyPassword: %s", secretValue(configs.PrivateKeyPassword))
+
+	logger.Printf("- JUnitReportPath: %s", configs.JUnitReportPath)
+	logger.Printf("- MaxPrintedFailures: %s", configs.MaxPrintedFailures)
+	logger.Printf("- TestResultOutputs: %s", configs.TestResultOutputs)
+	logger.Printf("- QuarantineFile: %s", configs.QuarantineFile)
+
+	logger.Printf("- DryRun: %s", configs.DryRun)
+	logger.Printf("- DryRunPlanPath: %s", configs.DryRunPlanPath)
+
+	logger.Printf("- LogFormat: %s", configs.LogFormat)
 }
 
 func (configs ConfigsModel) validate() error {
@@ -74,7 +164,7 @@ func (configs ConfigsModel) validate() error {
 	}
 
 	if configs.AndroidHome == "" {
-		return errors.New("no ApkPath parameter specified")
+		return errors.New("no AndroidHome parameter specified and ANDROID_SDK_ROOT is not set")
 	}
 	if exist, err := pathutil.IsDirExists(configs.AndroidHome); err != nil {
 		return fmt.Errorf("failed to check if AndroidHome exist, error: %s", err)
@@ -82,447 +172,700 @@ func (configs ConfigsModel) validate() error {
 		return fmt.Errorf("AndroidHome directory not exists at: %s", configs.AndroidHome)
 	}
 
+	if configs.ParallelRun != "yes" && configs.ParallelRun != "no" {
+		return fmt.Errorf("invalid ParallelRun: %s, available: yes, no", configs.ParallelRun)
+	}
+
+	if configs.CaptureLogcat != "yes" && configs.CaptureLogcat != "no" {
+		return fmt.Errorf("invalid CaptureLogcat: %s, available: yes, no", configs.CaptureLogcat)
+	}
+
+	if configs.LogcatAppOnly != "yes" && configs.LogcatAppOnly != "no" {
+		return fmt.Errorf("invalid LogcatAppOnly: %s, available: yes, no", configs.LogcatAppOnly)
+	}
+
+	if configs.FailOnCrash != "yes" && configs.FailOnCrash != "no" {
+		return fmt.Errorf("invalid FailOnCrash: %s, available: yes, no", configs.FailOnCrash)
+	}
+
+	if configs.DryRun != "yes" && configs.DryRun != "no" {
+		return fmt.Errorf("invalid DryRun: %s, available: yes, no", configs.DryRun)
+	}
+
+	if configs.RetryFailedCount != "" {
+		if count, err := strconv.Atoi(configs.RetryFailedCount); err != nil || count < 0 {
+			return fmt.Errorf("invalid RetryFailedCount: %s, should be a non-negative number", configs.RetryFailedCount)
+		}
+	}
+	if configs.MaxPrintedFailures != "" {
+		if count, err := strconv.Atoi(configs.MaxPrintedFailures); err != nil || count < 0 {
+			return fmt.Errorf("invalid MaxPrintedFailures: %s, should be a non-negative number", configs.MaxPrintedFailures)
+		}
+	}
+
+	if _, err := testResultOutputKeys(configs.TestResultOutputs); err != nil {
+		return err
+	}
+
+	if configs.QuarantineFile != "" {
+		if exist, err := pathutil.IsPathExists(configs.QuarantineFile); err != nil {
+			return fmt.Errorf("failed to check if QuarantineFile exist, error: %s", err)
+		} else if !exist {
+			return fmt.Errorf("QuarantineFile not exists at: %s", configs.QuarantineFile)
+		}
+	}
+
+	if configs.RunTimeout != "" {
+		if seconds, err := strconv.Atoi(configs.RunTimeout); err != nil || seconds < 0 {
+			return fmt.Errorf("invalid RunTimeout: %s, should be a non-negative number of seconds", configs.RunTimeout)
+		}
+	}
+
+	if configs.BootTimeout != "" {
+		if seconds, err := strconv.Atoi(configs.BootTimeout); err != nil || seconds < 0 {
+			return fmt.Errorf("invalid BootTimeout: %s, should be a non-negative number of seconds", configs.BootTimeout)
+		}
+	}
+
+	if configs.InactivityTimeout != "" {
+		if seconds, err := strconv.Atoi(configs.InactivityTimeout); err != nil || seconds < 0 {
+			return fmt.Errorf("invalid InactivityTimeout: %s, should be a non-negative number of seconds", configs.InactivityTimeout)
+		}
+	}
+
 	return nil
 }
 
 func exportEnvironmentWithEnvman(keyStr, valueStr string) error {
 	cmd := command.New("envman", "add", "--key", keyStr)
 	cmd.SetStdin(strings.NewReader(valueStr))
-	return cmd.Run()
+	return cmdExecutor.Run(cmd)
 }
 
-func registerFail(format string, v ...interface{}) {
-	log.Errorf(format, v...)
+// exportOutputs exports the key-value pairs, a failed export is not fatal.
+func exportOutputs(outputs [][]string) {
+	for _, output := range outputs {
+		if err := exportEnvironmentWithEnvman(output[0], output[1]); err != nil {
+			logger.Warnf("Failed to export environment: %s, error: %s", output[0], err)
+		}
+	}
+}
+
+// Test results exported in the test result outputs.
+const (
+	testResultSucceeded = "succeeded"
+	testResultFailed    = "failed"
+	testResultTimedOut  = "timed_out"
+)
+
+// The outputs of the test result, BITRISE_XAMARIN_TEST_RESULT is kept for the existing workflows.
+const (
+	testResultKey        = "BITRISE_CALABASH_ANDROID_TEST_RESULT"
+	xamarinTestResultKey = "BITRISE_XAMARIN_TEST_RESULT"
+)
 
-	if err := exportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT", "failed"); err != nil {
-		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_RESULT", err)
+// testResultKeys are the outputs the test result is exported in, selected by the test_result_outputs input.
+var testResultKeys = []string{testResultKey, xamarinTestResultKey}
+
+// testResultOutputKeys returns the outputs of the test result selected by the test_result_outputs input.
+func testResultOutputKeys(outputs string) ([]string, error) {
+	switch outputs {
+	case "", "both":
+		return []string{testResultKey, xamarinTestResultKey}, nil
+	case "calabash":
+		return []string{testResultKey}, nil
+	case "xamarin":
+		return []string{xamarinTestResultKey}, nil
+	default:
+		return nil, fmt.Errorf("invalid TestResultOutputs: %s, available: both, calabash, xamarin", outputs)
 	}
+}
 
-	os.Exit(1)
+// exportTestResult exports the test result in the selected outputs.
+func exportTestResult(result string) {
+	outputs := [][]string{}
+	for _, key := range testResultKeys {
+		outputs = append(outputs, []string{key, result})
+	}
+	exportOutputs(outputs)
 }
 
-func calabashAndroidFromGemfileLockContent(content string) string {
-	relevantLines := []string{}
-	lines := strings.Split(content, "\n")
+func registerFail(format string, v ...interface{}) {
+	registerFailWithResult(testResultFailed, format, v...)
+}
 
-	specsStart := false
-	for _, line := range lines {
-		if strings.Contains(line, "specs:") {
-			specsStart = true
-		}
+func registerFailWithResult(result, format string, v ...interface{}) {
+	logger.Errorf(format, v...)
+	exportTestResult(result)
+	os.Exit(1)
+}
 
-		trimmed := strings.Trim(line, " ")
-		if trimmed == "" {
-			break
-		}
+func exportTestSummary(features []cucumber.Feature) {
+	summary := cucumber.NewSummary(features)
+
+	logger.Println()
+	logger.Infof("Test summary:")
+	logger.Printf("- passed: %d", summary.Passed)
+	logger.Printf("- failed: %d", summary.Failed)
+	logger.Printf("- skipped: %d", summary.Skipped)
+	logger.Printf("- undefined: %d", summary.Undefined)
+	logger.Printf("- pending: %d", summary.Pending)
+	logger.Printf("- duration: %s", summary.Duration)
+
+	exportOutputs([][]string{
+		{"BITRISE_CALABASH_ANDROID_TOTAL_COUNT", strconv.Itoa(summary.Total())},
+		{"BITRISE_CALABASH_ANDROID_PASSED_COUNT", strconv.Itoa(summary.Passed)},
+		{"BITRISE_CALABASH_ANDROID_FAILED_COUNT", strconv.Itoa(summary.Failed)},
+		{"BITRISE_CALABASH_ANDROID_SKIPPED_COUNT", strconv.Itoa(summary.Skipped)},
+		{"BITRISE_CALABASH_ANDROID_UNDEFINED_COUNT", strconv.Itoa(summary.Undefined)},
+		{"BITRISE_CALABASH_ANDROID_PENDING_COUNT", strconv.Itoa(summary.Pending)},
+		{"BITRISE_CALABASH_ANDROID_TEST_DURATION", fmt.Sprintf("%.3f", summary.Duration.Seconds())},
+	})
+}
 
-		if specsStart {
-			relevantLines = append(relevantLines, line)
-		}
+func exportFlakyScenarios(flaky []cucumber.Scenario) {
+	lines := []string{}
+	for _, scenario := range flaky {
+		lines = append(lines, fmt.Sprintf("%s (%s)", scenario.Name, scenario.Location()))
 	}
 
-	exp := regexp.MustCompile(`calabash-android \((.+)\)`)
-	for _, line := range relevantLines {
-		match := exp.FindStringSubmatch(line)
-		if match != nil && len(match) == 2 {
-			return match[1]
+	if len(lines) > 0 {
+		logger.Println()
+		logger.Warnf("Flaky scenarios, failed at first but passed on rerun:")
+		for _, line := range lines {
+			logger.Warnf("- %s", line)
 		}
 	}
 
-	return ""
+	exportOutputs([][]string{
+		{"BITRISE_CALABASH_ANDROID_FLAKY_COUNT", strconv.Itoa(len(flaky))},
+		{"BITRISE_CALABASH_ANDROID_FLAKY_SCENARIOS", strings.Join(lines, "\n")},
+	})
 }
 
-func calabashAndroidVersionFromGemfileLock(gemfileLockPth string) (string, error) {
-	content, err := fileutil.ReadStringFromFile(gemfileLockPth)
-	if err != nil {
-		return "", err
+// junitReportPath returns the configured junit report path,
+// or a path in the Bitrise test result dir if no path is configured.
+func junitReportPath(configuredPth string) string {
+	if configuredPth != "" {
+		return configuredPth
+	}
+	if testResultDir := os.Getenv("BITRISE_TEST_RESULT_DIR"); testResultDir != "" {
+		return filepath.Join(testResultDir, "calabash-android", "TEST-calabash-android.xml")
 	}
-	return calabashAndroidFromGemfileLockContent(content), nil
+	return ""
 }
 
-func getLatestAAPT(androidHome string) (string, error) {
-	// $ANDROID_HOME/build-tools/24.0.2/aapt
+// screenshotDir is the dir of the screenshots taken by calabash in the run, every shard has a subdir.
+func screenshotDir(reportDir string) string {
+	return filepath.Join(reportDir, "screenshots")
+}
 
-	pattern := filepath.Join(androidHome, "build-tools", "*", "aapt")
-	files, err := filepath.Glob(pattern)
+func screenshotsZipPath() string {
+	if deployDir := os.Getenv("BITRISE_DEPLOY_DIR"); deployDir != "" {
+		return filepath.Join(deployDir, "calabash-android_screenshots.zip")
+	}
+	return ""
+}
+
+// exportScreenshots zips the screenshots of the run into the deploy dir,
+// the screenshots embedded in the report are named by feature and scenario.
+func exportScreenshots(jsonReportPth, dir string) {
+	// the screenshots of a run without report are collected as well, the parse error is already printed
+	features, err := cucumber.ParseReportFile(jsonReportPth)
 	if err != nil {
-		return "", err
+		features = nil
 	}
 
-	var latestVersion *version.Version
-	for _, file := range files {
-		dir := filepath.Dir(file)
-		base := filepath.Base(dir)
+	screenshots, err := screenshot.Collect(dir, features)
+	if err != nil {
+		logger.Warnf("Failed to collect screenshots, error: %s", err)
+		return
+	}
+	if len(screenshots) == 0 {
+		logger.Printf("no screenshots taken")
+		return
+	}
+	exportOutputs([][]string{{"BITRISE_CALABASH_ANDROID_SCREENSHOTS_DIR", dir}})
 
-		ver, err := version.NewVersion(base)
-		if err != nil {
-			return "", err
-		}
+	zipPth := screenshotsZipPath()
+	if zipPth == "" {
+		logger.Warnf("BITRISE_DEPLOY_DIR is not set, %d screenshots left in: %s", len(screenshots), dir)
+		return
+	}
 
-		if latestVersion == nil || latestVersion.LessThan(ver) {
-			latestVersion = ver
-		}
+	if err := screenshot.WriteZip(zipPth, screenshots); err != nil {
+		logger.Warnf("Failed to zip screenshots, error: %s", err)
+		return
 	}
+	logger.Donef("%d screenshots zipped to: %s", len(screenshots), zipPth)
+
+	exportOutputs([][]string{{"BITRISE_CALABASH_ANDROID_SCREENSHOTS_ZIP_PATH", zipPth}})
+}
 
-	if latestVersion == nil {
-		return "", errors.New("failed to find latest aapt version")
+func exportJUnitReport(features []cucumber.Feature, junitReportPth string) error {
+	testSuites := junit.NewTestSuites("calabash-android", features)
+	if err := junit.WriteToFile(junitReportPth, testSuites); err != nil {
+		return err
 	}
-	aapt := filepath.Join(androidHome, "build-tools", latestVersion.String(), "aapt")
-	if exist, err := pathutil.IsPathExists(aapt); err != nil {
-		return "", err
-	} else if !exist {
-		return "", fmt.Errorf("aapt not exists at: %s", aapt)
+
+	// results in the test result dir are only picked up with a test-info.json next to them
+	testResultDir := os.Getenv("BITRISE_TEST_RESULT_DIR")
+	if testResultDir != "" && filepath.Dir(filepath.Dir(junitReportPth)) == testResultDir {
+		testInfoPth := filepath.Join(filepath.Dir(junitReportPth), "test-info.json")
+		if err := fileutil.WriteStringToFile(testInfoPth, `{"test-name":"calabash-android"}`); err != nil {
+			return err
+		}
 	}
 
-	return aapt, nil
+	logger.Printf("JUnit report: %s", junitReportPth)
+	return nil
 }
 
-func ensureAPKInternetPermission(apkPth, androidHome string) error {
-	aapt, err := getLatestAAPT(androidHome)
+// processTestResults parses the cucumber json report and exports the test summary and the junit report.
+func processTestResults(jsonReportPth, junitReportPth string) {
+	features, err := cucumber.ParseReportFile(jsonReportPth)
 	if err != nil {
-		return err
+		logger.Warnf("Failed to parse cucumber json report (%s), error: %s", jsonReportPth, err)
+		return
 	}
 
-	args := []string{"d", "permissions", apkPth}
-	cmd := command.New(aapt, args...)
+	exportTestSummary(features)
+	exportOutputs([][]string{{"BITRISE_CALABASH_ANDROID_JSON_REPORT_PATH", jsonReportPth}})
 
-	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if junitReportPth != "" {
+		if err := exportJUnitReport(features, junitReportPth); err != nil {
+			logger.Warnf("Failed to export junit report (%s), error: %s", junitReportPth, err)
+		} else {
+			exportOutputs([][]string{{"BITRISE_CALABASH_ANDROID_JUNIT_REPORT_PATH", junitReportPth}})
+		}
+	}
+}
+
+// ensureAPKInternetPermissionWithSDKTools checks the permissions of the apk with the Android SDK tools,
+// used if the apk manifest can not be decoded natively.
+func ensureAPKInternetPermissionWithSDKTools(apkPth, androidHome, buildToolsVersion string) error {
+	tools, err := sdk.New(androidHome).PermissionTools(buildToolsVersion)
 	if err != nil {
 		return err
 	}
 
-	if !strings.Contains(out, "android.permission.INTERNET") {
-		return errors.New("apk has no internet permission")
-	}
+	for _, tool := range tools {
+		cmd := tool.Command(apkPth)
 
-	return nil
-}
+		logCommand(cmd)
 
-func indexInStringSlice(value string, list []string) int {
-	for i, v := range list {
-		if v == value {
-			return i
+		out, err := cmdExecutor.RunAndReturnTrimmedCombinedOutput(cmd)
+		if err != nil {
+			logger.Warnf("%s failed, output: %s, error: %s", tool.Name, out, err)
+			continue
 		}
+
+		for _, permission := range tool.ParsePermissions(out) {
+			if permission == apkinfo.InternetPermission {
+				return nil
+			}
+		}
+		return errors.New("apk has no internet permission")
 	}
-	return -1
+
+	return errors.New("failed to dump apk permissions with any of the SDK tools")
 }
 
-func main() {
-	configs := createConfigsModelFromEnvs()
+// inspectAPK reads the apk manifest and ensures the apk has internet permission.
+// The returned manifest is nil if the manifest could only be checked with the SDK tools.
+func inspectAPK(apkPth, androidHome, buildToolsVersion string) (*apkinfo.Manifest, error) {
+	manifest, err := apkinfo.ReadManifest(apkPth)
+	if err != nil {
+		logger.Warnf("Failed to read apk manifest, error: %s", err)
+		logger.Printf("Checking apk permissions with the Android SDK tools")
 
-	fmt.Println()
-	configs.print()
+		return nil, ensureAPKInternetPermissionWithSDKTools(apkPth, androidHome, buildToolsVersion)
+	}
 
-	if err := configs.validate(); err != nil {
-		registerFail("Issue with input: %s", err)
+	logger.Printf("- PackageName: %s", manifest.PackageName)
+	logger.Printf("- VersionCode: %d", manifest.VersionCode)
+	logger.Printf("- VersionName: %s", manifest.VersionName)
+	logger.Printf("- MinSDKVersion: %d", manifest.MinSDKVersion)
+	logger.Printf("- TargetSDKVersion: %d", manifest.TargetSDKVersion)
+	logger.Printf("- Debuggable: %v", manifest.Debuggable)
+	logger.Printf("- LaunchableActivity: %s", manifest.LaunchableActivity)
+	logger.Printf("- Permissions: %s", strings.Join(manifest.Permissions, ", "))
+
+	if !manifest.HasPermission(apkinfo.InternetPermission) {
+		return nil, errors.New("apk has no internet permission")
 	}
 
-	//
-	// Ensure apk
-	if err := ensureAPKInternetPermission(configs.ApkPath, configs.AndroidHome); err != nil {
-		registerFail("Failed to ensure apk internet permission, error: %s", err)
+	return &manifest, nil
+}
+
+// selectDevice returns the device with the given serial,
+// or the first online device if no serial is specified.
+func selectDevice(devices []adb.Device, serial string) (adb.Device, error) {
+	if serial != "" {
+		return adb.FindDevice(devices, serial)
 	}
-	// ---
 
-	options, err := shellquote.Split(configs.Options)
-	if err != nil {
-		registerFail("Failed to split additional options (%s), error: %s", configs.Options, err)
+	online := adb.OnlineDevices(devices)
+	if len(online) == 0 {
+		return adb.Device{}, fmt.Errorf("no online device found, available devices:\n%s", adb.DeviceList(devices))
+	}
+	if len(online) > 1 {
+		logger.Warnf("Multiple online devices found:\n%s", adb.DeviceList(online))
+		logger.Warnf("Using the first one, specify device_serial input to select a device")
 	}
 
-	//
-	// Determining calabash-android version
-	fmt.Println()
-	log.Infof("Determining calabash-android version...")
+	return online[0], nil
+}
 
-	workDir, err := pathutil.AbsPath(configs.WorkDir)
-	if err != nil {
-		registerFail("Failed to expand WorkDir (%s), error: %s", configs.WorkDir, err)
+// readReportFailures prints where the report is available, and returns the failed scenarios of a html report.
+// The whole content of any other report is printed.
+func readReportFailures(report cucumber.Report) ([]cucumber.HTMLFailure, error) {
+	// if --out is BITRISE_DEPLOY_DIR, print Deploy to bitrise.io step usage
+	if filepath.Dir(report.Path) == os.Getenv("BITRISE_DEPLOY_DIR") {
+		logger.Printf("Use Deploy to bitrise.io step to attach report file (%s) to your build artifacts.", report.Path)
+	} else {
+		logger.Printf("The generated report file is available at: %s", report.Path)
 	}
 
-	gemFilePath := ""
-	if configs.GemFilePath != "" {
-		gemFilePath, err = pathutil.AbsPath(configs.GemFilePath)
-		if err != nil {
-			registerFail("Failed to expand GemFilePath (%s), error: %s", configs.GemFilePath, err)
-		}
+	if report.Format == "html" {
+		return cucumber.ParseHTMLReportFile(report.Path)
 	}
 
-	useBundler := false
+	outputFileContent, err := fileutil.ReadStringFromFile(report.Path)
+	if err != nil {
+		return nil, err
+	}
+	logger.Println()
+	logger.Printf("%s", outputFileContent)
+	return nil, nil
+}
 
-	if gemFilePath != "" {
-		if exist, err := pathutil.IsPathExists(gemFilePath); err != nil {
-			registerFail("Failed to check if Gemfile exists at (%s) exist, error: %s", gemFilePath, err)
-		} else if exist {
-			log.Printf("Gemfile exists at: %s", gemFilePath)
+// maxFailureMessageLines limits the printed lines of a failure message, the rest is usually the backtrace.
+const maxFailureMessageLines = 15
+
+// printFailureSummary prints the failed scenarios grouped by feature, up to maxFailures scenarios (0 means no limit).
+// The same scenario may fail in more reports (like in the report of a rerun), the last failure is printed.
+func printFailureSummary(failures []cucumber.HTMLFailure, maxFailures int) {
+	features := []string{}
+	byFeature := map[string][]cucumber.HTMLFailure{}
+	index := map[string]int{}
+	for _, failure := range failures {
+		key := strings.Join([]string{failure.Feature, failure.Scenario, failure.Location, failure.Step}, "|")
+		if i, ok := index[key]; ok {
+			byFeature[failure.Feature][i] = failure
+			continue
+		}
 
-			gemfileDir := filepath.Dir(gemFilePath)
-			gemfileLockPth := filepath.Join(gemfileDir, "Gemfile.lock")
+		if _, ok := byFeature[failure.Feature]; !ok {
+			features = append(features, failure.Feature)
+		}
+		index[key] = len(byFeature[failure.Feature])
+		byFeature[failure.Feature] = append(byFeature[failure.Feature], failure)
+	}
+	if len(index) == 0 {
+		return
+	}
 
-			if exist, err := pathutil.IsPathExists(gemfileLockPth); err != nil {
-				registerFail("Failed to check if Gemfile.lock exists at (%s), error: %s", gemfileLockPth, err)
-			} else if exist {
-				log.Printf("Gemfile.lock exists at: %s", gemfileLockPth)
+	logger.Println()
+	logger.Errorf("Failed scenarios (%d):", len(index))
 
-				version, err := calabashAndroidVersionFromGemfileLock(gemfileLockPth)
-				if err != nil {
-					registerFail("Failed to get calabash-android version from Gemfile.lock, error: %s", err)
-				}
+	printed := 0
+	for _, feature := range features {
+		if maxFailures > 0 && printed >= maxFailures {
+			break
+		}
 
-				log.Printf("calabash-android version in Gemfile.lock: %s", version)
+		logger.Println()
+		logger.Infof("Feature: %s", feature)
+		for _, failure := range byFeature[feature] {
+			if maxFailures > 0 && printed >= maxFailures {
+				break
+			}
+			printed++
+
+			entry := logger.With(logger.Fields{
+				"feature":       failure.Feature,
+				"scenario":      failure.Scenario,
+				"location":      failure.Location,
+				"step":          failure.Step,
+				"step_location": failure.StepLocation,
+			})
+			entry.Errorf("  Scenario: %s (%s)", failure.Scenario, failure.Location)
+			if failure.StepLocation != "" {
+				entry.Printf("    Step: %s (%s)", failure.Step, failure.StepLocation)
+			} else if failure.Step != "" {
+				entry.Printf("    Step: %s", failure.Step)
+			}
 
-				useBundler = true
-			} else {
-				log.Warnf("Gemfile.lock doest no find with calabash-android gem at: %s", gemfileLockPth)
+			lines := strings.Split(failure.Message, "\n")
+			if len(lines) > maxFailureMessageLines {
+				lines = append(lines[:maxFailureMessageLines], fmt.Sprintf("... (%d more lines)", len(lines)-maxFailureMessageLines))
+			}
+			for _, line := range lines {
+				entry.Printf("    %s", line)
 			}
-		} else {
-			log.Warnf("Gemfile doest no find with calabash-android gem at: %s", gemFilePath)
 		}
 	}
 
-	if configs.CalabashAndroidVersion != "" {
-		log.Donef("using calabash-android version: %s", configs.CalabashAndroidVersion)
-	} else if useBundler {
-		log.Donef("using calabash-android with bundler")
-	} else {
-		log.Donef("using calabash-android latest version")
+	if printed < len(index) {
+		logger.Println()
+		logger.Warnf("%d more failed scenarios are not printed, see the reports", len(index)-printed)
 	}
-	// ---
-
-	//
-	// Intsalling calabash-android gem
-	fmt.Println()
-	log.Infof("Installing calabash-android gem...")
+}
 
-	if configs.CalabashAndroidVersion != "" {
-		installed, err := rubycommand.IsGemInstalled("calabash-android", configs.CalabashAndroidVersion)
-		if err != nil {
-			registerFail("Failed to check if calabash-android (v%s) installed, error: %s", configs.CalabashAndroidVersion, err)
-		}
+// runCommand runs the command with the step's outputs, or adds it to the plan in dry run mode (plan is not nil).
+func runCommand(plan *executionPlan, stage string, cmd *command.Model) error {
+	if plan != nil {
+		plan.addCommand(stage, cmd)
+		return nil
+	}
 
-		if !installed {
-			installCommands, err := rubycommand.GemInstall("calabash-android", configs.CalabashAndroidVersion)
-			if err != nil {
-				registerFail("Failed to create gem install commands, error: %s", err)
-			}
+	flushOutput := setCommandOutput(cmd)
+	defer flushOutput()
 
-			for _, installCommand := range installCommands {
-				log.Printf("$ %s", command.PrintableCommandArgs(false, installCommand.GetCmd().Args))
+	logCommand(cmd)
 
-				installCommand.SetStdout(os.Stdout).SetStderr(os.Stderr)
+	return cmdExecutor.Run(cmd)
+}
 
-				if err := installCommand.Run(); err != nil {
-					registerFail("command failed, error: %s", err)
-				}
-			}
-		} else {
-			log.Printf("calabash-android %s installed", configs.CalabashAndroidVersion)
-		}
-	} else if useBundler {
-		bundleInstallCmd, err := rubycommand.New("bundle", "install", "--jobs", "20", "--retry", "5")
-		if err != nil {
-			registerFail("Failed to create command, error: %s", err)
-		}
+// validateOutput is the output of the validate stage.
+type validateOutput struct {
+	inputs stepInputs
+	// plan collects the commands in dry run mode instead of running them, nil otherwise
+	plan *executionPlan
+}
 
-		bundleInstallCmd.AppendEnvs("BUNDLE_GEMFILE=" + gemFilePath)
-		bundleInstallCmd.SetStdout(os.Stdout).SetStderr(os.Stderr)
+// deviceSelection is the output of the select device stage.
+type deviceSelection struct {
+	device adb.Device
+	// parallelDevices are the devices of the shards in parallel mode
+	parallelDevices []adb.Device
+}
 
-		log.Printf("$ %s", bundleInstallCmd.PrintableCommandArgs())
+// testsOutput is the output of the run stage.
+type testsOutput struct {
+	// ran is false if the tests were not run, like in dry run mode
+	ran    bool
+	failed bool
+	result testRunResult
+}
 
-		if err := bundleInstallCmd.Run(); err != nil {
-			registerFail("bundle install failed, error: %s", err)
-		}
-	} else {
-		installCommands, err := rubycommand.GemInstall("calabash-android", "")
-		if err != nil {
-			registerFail("Failed to create gem install commands, error: %s", err)
-		}
+func validateStage(configs ConfigsModel) (validateOutput, error) {
+	inputs, err := validateInputs(configs)
+	if err != nil {
+		return validateOutput{}, err
+	}
 
-		for _, installCommand := range installCommands {
-			log.Printf("$ %s", command.PrintableCommandArgs(false, installCommand.GetCmd().Args))
+	output := validateOutput{inputs: inputs}
+	if configs.DryRun == "yes" {
+		logger.Warnf("Dry run: the commands of the step will be printed, but not run")
+		output.plan = &executionPlan{}
+	}
+	return output, nil
+}
 
-			installCommand.SetStdout(os.Stdout).SetStderr(os.Stderr)
+func inspectAPKStage(configs ConfigsModel, plan *executionPlan) (*apkinfo.Manifest, error) {
+	manifest, err := inspectAPK(configs.ApkPath, configs.AndroidHome, configs.BuildToolsVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure apk internet permission, error: %s", err)
+	}
 
-			if err := installCommand.Run(); err != nil {
-				registerFail("command failed, error: %s", err)
-			}
-		}
+	if manifest == nil {
+		return nil, nil
 	}
-	// ---
+	if plan != nil {
+		plan.APKPackageName = manifest.PackageName
+	} else {
+		exportOutputs([][]string{{"BITRISE_CALABASH_ANDROID_APK_PACKAGE_NAME", manifest.PackageName}})
+	}
+	return manifest, nil
+}
 
-	//
-	// Search for debug.keystore
-	fmt.Println()
-	log.Infof("Search for debug.keystore...")
+func selectDeviceStage(configs ConfigsModel, validated validateOutput) (deviceSelection, error) {
+	device, parallelDevices, err := selectDevices(configs.AndroidHome, configs.DeviceSerial, configs.ParallelRun == "yes", validated.inputs.bootTimeout, validated.plan)
+	if err != nil {
+		return deviceSelection{}, err
+	}
+	return deviceSelection{device: device, parallelDevices: parallelDevices}, nil
+}
 
-	debugKeystorePth := ""
-	homeDir := pathutil.UserHomeDir()
+func waitForBootStage(configs ConfigsModel, validated validateOutput, selection deviceSelection) error {
+	if validated.inputs.bootTimeout == 0 {
+		return errStageSkipped
+	}
 
-	// $HOME/.android/debug.keystore
-	androidDebugKeystorePth := filepath.Join(homeDir, ".android", "debug.keystore")
-	debugKeystorePth = androidDebugKeystorePth
+	devices := selection.parallelDevices
+	if len(devices) == 0 {
+		devices = []adb.Device{selection.device}
+	}
 
-	if exist, err := pathutil.IsPathExists(androidDebugKeystorePth); err != nil {
-		registerFail("Failed to check if debug.keystore exists at (%s), error: %s", androidDebugKeystorePth, err)
-	} else if !exist {
-		log.Warnf("android debug keystore not exist at: %s", androidDebugKeystorePth)
+	if validated.plan != nil {
+		validated.plan.addNote("would wait up to %s for the devices to boot (sys.boot_completed, init.svc.bootanim)", validated.inputs.bootTimeout)
+		return nil
+	}
+	return waitForBoot(configs.AndroidHome, devices, validated.inputs.bootTimeout)
+}
 
-		// $HOME/.local/share/Mono for Android/debug.keystore
-		xamarinDebugKeystorePth := filepath.Join(homeDir, ".local", "share", "Mono for Android", "debug.keystore")
+func installGemStage(setup gemSetup, plan *executionPlan) error {
+	resolvedVersion, err := installCalabashAndroid(setup, plan)
+	if err != nil {
+		return err
+	}
 
-		log.Printf("checking xamarin debug keystore at: %s", xamarinDebugKeystorePth)
+	if plan == nil && resolvedVersion != "" {
+		exportOutputs([][]string{{"BITRISE_CALABASH_ANDROID_VERSION", resolvedVersion}})
+	}
+	return nil
+}
 
-		if exist, err := pathutil.IsPathExists(xamarinDebugKeystorePth); err != nil {
-			registerFail("Failed to check if debug.keystore exists at (%s), error: %s", xamarinDebugKeystorePth, err)
-		} else if !exist {
-			log.Warnf("xamarin debug keystore not exist at: %s", xamarinDebugKeystorePth)
-			log.Printf("generating debug keystore")
+// runStage runs the tests, or adds the commands of the run to the plan in dry run mode.
+// The returned output holds the result of the run even if the tests failed.
+func runStage(configs ConfigsModel, validated validateOutput, manifest *apkinfo.Manifest, selection deviceSelection, setup gemSetup) (testsOutput, error) {
+	run := testRun{
+		calabash:        setup.calabash(validated.inputs.workDir),
+		apkPth:          configs.ApkPath,
+		device:          selection.device,
+		parallelDevices: selection.parallelDevices,
+		inputs:          validated.inputs,
+		androidHome:     configs.AndroidHome,
+	}
+	if manifest != nil {
+		run.packageName = manifest.PackageName
+	}
 
-			// `keytool -genkey -v -keystore "#{debug_keystore}" -alias androiddebugkey -storepass android -keypass android -keyalg RSA -keysize 2048 -validity 10000 -dname "CN=Android Debug,O=Android,C=US"`
-			keytoolArgs := []string{"keytool", "-genkey", "-v", "-keystore", debugKeystorePth, "-alias", "androiddebugkey", "-storepass", "android", "-keypass", "android", "-keyalg", "RSA", "-keysize", "2048", "-validity", "10000", "-dname", "CN=Android Debug,O=Android,C=US"}
+	if validated.plan != nil {
+		return testsOutput{}, run.addToPlan(validated.plan)
+	}
 
-			cmd, err := command.NewFromSlice(keytoolArgs...)
-			if err != nil {
-				registerFail("Failed to create command, error: %s", err)
-			}
+	result, err := run.execute()
+	return testsOutput{ran: true, failed: err != nil, result: result}, err
+}
 
-			log.Printf("$ %s", command.PrintableCommandArgs(false, keytoolArgs))
+// reportStage exports the results of the tests, or prints the plan in dry run mode.
+func reportStage(configs ConfigsModel, validated validateOutput, tests testsOutput) error {
+	if validated.plan != nil {
+		validated.plan.print()
 
-			if err := cmd.Run(); err != nil {
-				registerFail("Failed to generate debug.keystore, error: %s", err)
+		if configs.DryRunPlanPath != "" {
+			if err := validated.plan.writeJSON(configs.DryRunPlanPath); err != nil {
+				return fmt.Errorf("failed to write execution plan, error: %s", err)
 			}
+			logger.Println()
+			logger.Donef("execution plan written to: %s", configs.DryRunPlanPath)
+		}
+		return nil
+	}
 
-			log.Printf("using debug keystore: %s", debugKeystorePth)
-		} else {
-			log.Printf("using xamarin debug keystore: %s", xamarinDebugKeystorePth)
+	if !tests.ran {
+		return errStageSkipped
+	}
 
-			debugKeystorePth = xamarinDebugKeystorePth
-		}
-	} else {
-		log.Printf("using android debug keystore: %s", androidDebugKeystorePth)
+	if tests.result.timeoutReason != "" {
+		exportOutputs([][]string{{"BITRISE_CALABASH_ANDROID_TIMEOUT_REASON", tests.result.timeoutReason}})
 	}
-	// ---
+	return reportResults(validated.inputs, tests.result, tests.failed)
+}
 
-	//
-	// Resign apk with debug.keystore
-	fmt.Println()
-	log.Infof("Resign apk with debug.keystore...")
+func main() {
+	configs := createConfigsModelFromEnvs()
 
-	{
-		resignEnvs := []string{}
+	// an invalid input is reported by the validate stage, the result is exported in both outputs until then
+	if keys, err := testResultOutputKeys(configs.TestResultOutputs); err == nil {
+		testResultKeys = keys
+	}
 
-		resignArgs := []string{"calabash-android"}
-		if configs.CalabashAndroidVersion != "" {
-			resignArgs = append(resignArgs, fmt.Sprintf("_%s_", configs.CalabashAndroidVersion))
-		} else if useBundler {
-			resignArgs = append([]string{"bundle", "exec"}, resignArgs...)
-			resignEnvs = append(resignEnvs, "BUNDLE_GEMFILE="+gemFilePath)
+	if configs.LogFormat != "" {
+		if err := logger.SetFormat(configs.LogFormat); err != nil {
+			registerFail("Issue with input: %s", err)
 		}
+	}
 
-		resignArgs = append(resignArgs, "resign", configs.ApkPath)
+	logger.Println()
+	configs.print()
 
-		resignCmd, err := rubycommand.NewFromSlice(resignArgs...)
-		if err != nil {
-			registerFail("Failed to create command, error: %s", err)
-		}
+	// the outputs of the stages, every stage gets the outputs of the previous stages it depends on as arguments
+	var validated validateOutput
+	var manifest *apkinfo.Manifest
+	var devices deviceSelection
+	var setup gemSetup
+	var restoreCalabashSettings func() error
+	var tests testsOutput
 
-		resignCmd.AppendEnvs(resignEnvs...)
-		resignCmd.SetDir(workDir)
-		resignCmd.SetStdout(os.Stdout).SetStderr(os.Stderr)
+	p := pipeline{}
 
-		log.Printf("$ %s", resignCmd.PrintableCommandArgs())
-		fmt.Println()
+	p.add(stageValidate, "Validating inputs...", func() (err error) {
+		validated, err = validateStage(configs)
+		return err
+	})
 
-		if err := resignCmd.Run(); err != nil {
-			registerFail("Failed to run command, error: %s", err)
-		}
-	}
-	// ---
+	p.add(stageInspectAPK, "Inspecting apk...", func() (err error) {
+		manifest, err = inspectAPKStage(configs, validated.plan)
+		return err
+	})
 
-	//
-	// Run calabash-android
-	fmt.Println()
-	log.Infof("Running calabash-android test...")
+	p.add(stageSelectDevice, "Selecting device...", func() (err error) {
+		devices, err = selectDeviceStage(configs, validated)
+		return err
+	})
 
-	{
-		runEnvs := []string{}
+	p.add(stageWaitForBoot, "Waiting for device boot...", func() error {
+		return waitForBootStage(configs, validated, devices)
+	})
 
-		runArgs := []string{"calabash-android"}
-		if configs.CalabashAndroidVersion != "" {
-			runArgs = append(runArgs, fmt.Sprintf("_%s_", configs.CalabashAndroidVersion))
-		} else if useBundler {
-			runArgs = append([]string{"bundle", "exec"}, runArgs...)
-			runEnvs = append(runEnvs, "BUNDLE_GEMFILE="+gemFilePath)
-		}
+	p.add(stageResolveGem, "Determining calabash-android version...", func() (err error) {
+		setup, err = resolveCalabashAndroid(configs.CalabashAndroidVersion, validated.inputs.gemFilePath)
+		return err
+	})
 
-		runArgs = append(runArgs, "run", configs.ApkPath)
-		runArgs = append(runArgs, options...)
+	p.add(stageInstallGem, "Installing calabash-android gem...", func() error {
+		return installGemStage(setup, validated.plan)
+	})
 
-		runCmd, err := rubycommand.NewFromSlice(runArgs...)
-		if err != nil {
-			registerFail("Failed to create command, error: %s", err)
-		}
+	p.add(stageKeystore, "Preparing keystore...", func() (err error) {
+		restoreCalabashSettings, err = prepareKeystore(configs, validated.inputs.workDir, validated.plan)
+		return err
+	})
 
-		runCmd.AppendEnvs(runEnvs...)
-		runCmd.SetDir(workDir)
-		runCmd.SetStdout(os.Stdout).SetStderr(os.Stderr)
+	p.add(stageResign, "Resign apk...", func() error {
+		return resignAPK(setup.calabash(validated.inputs.workDir), configs.ApkPath, validated.plan)
+	})
 
-		log.Printf("$ %s", runCmd.PrintableCommandArgs())
-		fmt.Println()
+	p.add(stageRun, "Running calabash-android test...", func() (err error) {
+		tests, err = runStage(configs, validated, manifest, devices, setup)
+		return err
+	})
 
-		if err := runCmd.Run(); err != nil {
-			fmt.Println()
-			log.Errorf("Failed to run command, error: %s", err)
-			if err := exportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT", "failed"); err != nil {
-				log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_RESULT", err)
-			}
+	p.addAlways(stageReport, "Processing test results...", func() error {
+		return reportStage(configs, validated, tests)
+	})
 
-			// find --out flag and get the next index containing output file's pth
-			outputFilePth := ""
-			if index := indexInStringSlice("--out", options); index != -1 {
-				outputFilePth = options[index+1]
-			}
-			if outputFilePth == "" {
-				os.Exit(1)
+	pipelineErr := func() error {
+		// the settings hold the keystore passwords, restore them even if a stage panics
+		defer func() {
+			if restoreCalabashSettings == nil {
+				return
 			}
-
-			// if --out is BITRISE_DEPLOY_DIR, print Deploy to bitrise.io step usage
-			if filepath.Dir(outputFilePth) == os.Getenv("BITRISE_DEPLOY_DIR") {
-				log.Printf("Use Deploy to bitrise.io step to attach report file (%s) to your build artifacts.", outputFilePth)
-			} else {
-				log.Printf("The generated report file is available at: %s", outputFilePth)
+			if err := restoreCalabashSettings(); err != nil {
+				logger.Warnf("Failed to restore %s, error: %s", calabashSettingsFileName, err)
 			}
-			fmt.Println()
+		}()
 
-			// read output file
-			outputFileContent, err := fileutil.ReadStringFromFile(outputFilePth)
-			if err != nil {
-				registerFail("Failed to read output file (%s), error: %s", outputFilePth, err)
-			}
+		return p.run()
+	}()
 
-			// check if output format is html
-			if index := indexInStringSlice("--format", options); index != -1 && (options[index+1] == "html") {
-				// regex messages from output html and avoid duplicating messages
-				outputs := []string{}
-				exp := regexp.MustCompile(`<div class="message"><pre>(?s)(.*?)</pre></div>`)
-				for _, match := range exp.FindAllStringSubmatch(outputFileContent, -1) {
-					if len(match) > 1 {
-						if index := indexInStringSlice(match[1], outputs); index == -1 {
-							log.Printf(match[1])
-							outputs = append(outputs, match[1])
-						}
-					}
-				}
-				os.Exit(1)
-			}
+	p.printSummary()
 
-			// output isn't html, print file content
-			log.Printf(outputFileContent)
-			os.Exit(1)
+	if pipelineErr != nil {
+		logger.Println()
+		if tests.result.timeoutReason != "" {
+			registerFailWithResult(testResultTimedOut, "%s", pipelineErr)
 		}
+		registerFail("%s", pipelineErr)
 	}
-	// ---
 
-	if err := exportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT", "succeeded"); err != nil {
-		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_RESULT", err)
+	if validated.plan != nil {
+		return
 	}
+
+	exportTestResult(testResultSucceeded)
 }
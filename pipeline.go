@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+)
+
+// Stage statuses.
+const (
+	stageStatusSucceeded = "succeeded"
+	stageStatusFailed    = "failed"
+	stageStatusSkipped   = "skipped"
+)
+
+// errStageSkipped is returned by a stage, which has nothing to do.
+var errStageSkipped = errors.New("stage skipped")
+
+// stage is a named step of the pipeline.
+type stage struct {
+	name  string
+	title string
+	// always stages run even if a previous stage failed, like the report of a failed test run
+	always bool
+	run    func() error
+}
+
+// stageResult is the status and timing of a stage.
+type stageResult struct {
+	name      string
+	status    string
+	startTime time.Time
+	endTime   time.Time
+}
+
+func (result stageResult) duration() time.Duration {
+	return result.endTime.Sub(result.startTime)
+}
+
+// pipeline runs the stages of the step in order and records their results.
+type pipeline struct {
+	stages  []stage
+	results []stageResult
+}
+
+func (p *pipeline) add(name, title string, run func() error) {
+	p.stages = append(p.stages, stage{name: name, title: title, run: run})
+}
+
+func (p *pipeline) addAlways(name, title string, run func() error) {
+	p.stages = append(p.stages, stage{name: name, title: title, always: true, run: run})
+}
+
+// run runs the stages, the stages after a failed one are skipped, except the always stages.
+// It returns the error of the first failed stage.
+func (p *pipeline) run() error {
+	var pipelineErr error
+
+	for _, s := range p.stages {
+		if pipelineErr != nil && !s.always {
+			p.results = append(p.results, stageResult{name: s.name, status: stageStatusSkipped})
+			continue
+		}
+
+		logger.SetStage(s.name)
+		logger.Println()
+		logger.Infof("%s", s.title)
+
+		result := stageResult{name: s.name, startTime: time.Now()}
+		err := s.run()
+		result.endTime = time.Now()
+
+		switch {
+		case err == errStageSkipped:
+			result.status = stageStatusSkipped
+		case err != nil:
+			result.status = stageStatusFailed
+
+			logger.Errorf("%s", err)
+
+			if pipelineErr == nil {
+				pipelineErr = fmt.Errorf("%s stage failed, error: %s", s.name, err)
+			}
+		default:
+			result.status = stageStatusSucceeded
+		}
+
+		p.results = append(p.results, result)
+
+		logger.With(logger.Fields{
+			"status":      result.status,
+			"duration_ms": result.duration().Milliseconds(),
+		}).Debugf("%s stage %s", s.name, result.status)
+	}
+	logger.SetStage("")
+
+	return pipelineErr
+}
+
+// printSummary prints the status and duration of the stages.
+func (p pipeline) printSummary() {
+	logger.Println()
+	logger.Infof("Stage summary:")
+
+	if logger.IsJSON() {
+		p.logSummary()
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(w, "STAGE\tSTATUS\tSTART\tDURATION"); err != nil {
+		logger.Warnf("Failed to print stage summary, error: %s", err)
+		return
+	}
+
+	var total time.Duration
+	for _, result := range p.results {
+		start, duration := "-", "-"
+		if !result.startTime.IsZero() {
+			start = result.startTime.Format("15:04:05")
+			duration = result.duration().Round(time.Millisecond).String()
+			total += result.duration()
+		}
+
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.name, result.status, start, duration); err != nil {
+			logger.Warnf("Failed to print stage summary, error: %s", err)
+			return
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "total\t\t\t%s\n", total.Round(time.Millisecond)); err != nil {
+		logger.Warnf("Failed to print stage summary, error: %s", err)
+		return
+	}
+
+	if err := w.Flush(); err != nil {
+		logger.Warnf("Failed to print stage summary, error: %s", err)
+	}
+}
+
+// logSummary prints an event per stage in json log format, instead of the table.
+func (p pipeline) logSummary() {
+	var total time.Duration
+	for _, result := range p.results {
+		fields := logger.Fields{"stage": result.name, "status": result.status}
+		if !result.startTime.IsZero() {
+			fields["start"] = result.startTime
+			fields["duration_ms"] = result.duration().Milliseconds()
+			total += result.duration()
+		}
+		logger.With(fields).Printf("%s: %s", result.name, result.status)
+	}
+	logger.With(logger.Fields{"duration_ms": total.Milliseconds()}).Printf("total: %s", total.Round(time.Millisecond))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+)
+
+// Stages of the execution plan.
+const (
+	planStageInstall = "install"
+	planStageResign  = "resign"
+	planStageRun     = "run"
+)
+
+// plannedCommand is a command the step would run.
+type plannedCommand struct {
+	Stage   string   `json:"stage"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// Envs are the environment variables set by the step, on top of the step's environment.
+	Envs []string `json:"envs,omitempty"`
+	Dir  string   `json:"dir,omitempty"`
+}
+
+// executionPlan is what the step would do, collected in dry run mode instead of running the commands.
+type executionPlan struct {
+	APKPackageName         string           `json:"apk_package_name,omitempty"`
+	CalabashAndroidVersion string           `json:"calabash_android_version,omitempty"`
+	UseBundler             bool             `json:"use_bundler"`
+	Keystore               string           `json:"keystore,omitempty"`
+	Devices                []string         `json:"devices,omitempty"`
+	Notes                  []string         `json:"notes,omitempty"`
+	Commands               []plannedCommand `json:"commands"`
+}
+
+// addedEnvs returns the envs of the command, which are not inherited from the step's environment.
+func addedEnvs(envs []string) []string {
+	if envs == nil {
+		return nil
+	}
+
+	inherited := map[string]bool{}
+	for _, env := range os.Environ() {
+		inherited[env] = true
+	}
+
+	added := []string{}
+	for _, env := range envs {
+		if !inherited[env] {
+			added = append(added, env)
+		}
+	}
+	return added
+}
+
+func (plan *executionPlan) addCommand(stage string, cmd *command.Model) {
+	execCmd := cmd.GetCmd()
+	plan.Commands = append(plan.Commands, plannedCommand{
+		Stage:   stage,
+		Command: cmd.PrintableCommandArgs(),
+		Args:    execCmd.Args,
+		Envs:    addedEnvs(execCmd.Env),
+		Dir:     execCmd.Dir,
+	})
+}
+
+func (plan *executionPlan) addNote(format string, v ...interface{}) {
+	plan.Notes = append(plan.Notes, fmt.Sprintf(format, v...))
+}
+
+func (plan executionPlan) print() {
+	logger.Infof("Execution plan:")
+	logger.Printf("- APKPackageName: %s", plan.APKPackageName)
+	logger.Printf("- CalabashAndroidVersion: %s", plan.CalabashAndroidVersion)
+	logger.Printf("- UseBundler: %v", plan.UseBundler)
+	logger.Printf("- Keystore: %s", plan.Keystore)
+	logger.Printf("- Devices: %s", strings.Join(plan.Devices, ", "))
+
+	for _, note := range plan.Notes {
+		logger.Warnf("%s", note)
+	}
+
+	for _, cmd := range plan.Commands {
+		logger.Println()
+		logger.Donef("[%s] $ %s", cmd.Stage, cmd.Command)
+		if cmd.Dir != "" {
+			logger.Printf("  dir: %s", cmd.Dir)
+		}
+		for _, env := range cmd.Envs {
+			logger.Printf("  env: %s", env)
+		}
+	}
+}
+
+// writeJSON writes the plan as JSON, plans of different step versions can be diffed.
+func (plan executionPlan) writeJSON(pth string) error {
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, content)
+}
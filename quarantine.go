@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/quarantine"
+)
+
+// quarantinedScenario is a scenario of the run listed in the quarantine list.
+type quarantinedScenario struct {
+	cucumber.Scenario
+	// reason is the entry of the quarantine list the scenario matched, like: tag @flaky
+	reason string
+}
+
+func (scenario quarantinedScenario) String() string {
+	return fmt.Sprintf("%s (%s), quarantined by %s", scenario.Name, scenario.Location(), scenario.reason)
+}
+
+// quarantineResult is the outcome of the quarantined scenarios of the run.
+type quarantineResult struct {
+	failed  []quarantinedScenario
+	passing []quarantinedScenario
+	// unquarantinedFailures is the number of the failed scenarios, which are not quarantined
+	unquarantinedFailures int
+}
+
+// onlyQuarantinedFailed reports whether the failed scenarios of the run are all quarantined.
+func (result quarantineResult) onlyQuarantinedFailed() bool {
+	return len(result.failed) > 0 && result.unquarantinedFailures == 0
+}
+
+// applyQuarantine sorts the scenarios of the report by the quarantine list.
+// In strict mode the undefined and pending scenarios fail the run as well.
+func applyQuarantine(list quarantine.List, features []cucumber.Feature, strict bool) quarantineResult {
+	result := quarantineResult{}
+	for _, feature := range features {
+		for _, scenario := range feature.Scenarios() {
+			status := scenario.Status()
+			failed := status == cucumber.StatusFailed ||
+				(strict && (status == cucumber.StatusUndefined || status == cucumber.StatusPending))
+
+			reason, quarantined := list.Match(scenario)
+			switch {
+			case quarantined && failed:
+				result.failed = append(result.failed, quarantinedScenario{Scenario: scenario, reason: reason})
+			case quarantined && status == cucumber.StatusPassed:
+				result.passing = append(result.passing, quarantinedScenario{Scenario: scenario, reason: reason})
+			case failed:
+				result.unquarantinedFailures++
+			}
+		}
+	}
+	return result
+}
+
+// isStrict reports whether cucumber fails the run on undefined and pending steps.
+func isStrict(options cucumber.Options) bool {
+	for _, option := range options {
+		if option.Name == "--strict" || option.Name == "-S" {
+			return true
+		}
+	}
+	return false
+}
+
+// reportQuarantine prints and exports the quarantined scenarios, which failed or passed in the run.
+func reportQuarantine(result quarantineResult) {
+	failed := []string{}
+	for _, scenario := range result.failed {
+		failed = append(failed, scenario.String())
+	}
+	passing := []string{}
+	for _, scenario := range result.passing {
+		passing = append(passing, scenario.String())
+	}
+
+	if len(result.failed) > 0 {
+		logger.Println()
+		logger.Warnf("Quarantined scenarios failed (%d), they do not fail the test result:", len(result.failed))
+		for _, scenario := range result.failed {
+			logger.Warnf("- %s", scenario)
+			if step, message := scenario.FailingStep(); step != "" {
+				logger.Printf("    %s: %s", step, strings.Split(message, "\n")[0])
+			}
+		}
+	}
+
+	if len(result.passing) > 0 {
+		logger.Println()
+		logger.Donef("Quarantined scenarios passed (%d), consider removing them from the quarantine list:", len(result.passing))
+		for _, scenario := range result.passing {
+			logger.Printf("- %s", scenario)
+		}
+	}
+
+	exportOutputs([][]string{
+		{"BITRISE_CALABASH_ANDROID_QUARANTINED_FAILED_COUNT", strconv.Itoa(len(result.failed))},
+		{"BITRISE_CALABASH_ANDROID_QUARANTINED_FAILED_SCENARIOS", strings.Join(failed, "\n")},
+		{"BITRISE_CALABASH_ANDROID_QUARANTINED_PASSED_SCENARIOS", strings.Join(passing, "\n")},
+	})
+}
@@ -0,0 +1,92 @@
+package quarantine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+	"gopkg.in/yaml.v2"
+)
+
+// List is the list of the quarantined scenarios, their failures do not fail the test run.
+type List struct {
+	// Features are feature file paths, like features/login.feature,
+	// or scenario locations, like features/login.feature:12
+	Features []string `json:"features" yaml:"features"`
+	// Scenarios are scenario names
+	Scenarios []string `json:"scenarios" yaml:"scenarios"`
+	// Tags are the tags of the scenarios or features, like @flaky
+	Tags []string `json:"tags" yaml:"tags"`
+}
+
+// Parse decodes a YAML or JSON quarantine list, unknown keys are errors.
+func Parse(content []byte) (List, error) {
+	var list List
+
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&list); err != nil {
+			return List{}, err
+		}
+	} else if err := yaml.UnmarshalStrict(content, &list); err != nil {
+		return List{}, err
+	}
+
+	if len(list.Features) == 0 && len(list.Scenarios) == 0 && len(list.Tags) == 0 {
+		return List{}, errors.New("no features, scenarios or tags listed")
+	}
+
+	for i, tag := range list.Tags {
+		if !strings.HasPrefix(tag, "@") {
+			list.Tags[i] = "@" + tag
+		}
+	}
+	return list, nil
+}
+
+// ReadFile decodes the YAML or JSON quarantine list at the given path.
+func ReadFile(pth string) (List, error) {
+	content, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return List{}, err
+	}
+	return Parse(content)
+}
+
+// matchFeature reports whether the feature path (or scenario location) matches the feature file uri of the report,
+// which may be relative to a different dir.
+func matchFeature(pth, uri string) bool {
+	pth, uri = filepath.Clean(pth), filepath.Clean(uri)
+	return pth == uri || strings.HasSuffix(uri, string(filepath.Separator)+pth) || strings.HasSuffix(pth, string(filepath.Separator)+uri)
+}
+
+// Match returns why the scenario is quarantined, like: tag @flaky, or false if it is not quarantined.
+func (list List) Match(scenario cucumber.Scenario) (string, bool) {
+	for _, feature := range list.Features {
+		if matchFeature(feature, scenario.FeatureURI) || matchFeature(feature, scenario.Location()) {
+			return "feature " + feature, true
+		}
+	}
+
+	for _, name := range list.Scenarios {
+		if name == scenario.Name {
+			return "scenario " + name, true
+		}
+	}
+
+	tags := append(append([]cucumber.Tag{}, scenario.FeatureTags...), scenario.Tags...)
+	for _, quarantined := range list.Tags {
+		for _, tag := range tags {
+			if tag.Name == quarantined {
+				return "tag " + quarantined, true
+			}
+		}
+	}
+
+	return "", false
+}
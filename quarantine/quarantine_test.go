@@ -0,0 +1,66 @@
+package quarantine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+)
+
+func TestParse(t *testing.T) {
+	want := List{
+		Features:  []string{"features/login.feature:8"},
+		Scenarios: []string{"Invalid login"},
+		Tags:      []string{"@flaky", "@wip"},
+	}
+
+	for name, content := range map[string]string{
+		"yaml": "features:\n  - features/login.feature:8\nscenarios:\n  - Invalid login\ntags:\n  - flaky\n  - \"@wip\"\n",
+		"json": `{"features": ["features/login.feature:8"], "scenarios": ["Invalid login"], "tags": ["flaky", "@wip"]}`,
+	} {
+		list, err := Parse([]byte(content))
+		if err != nil {
+			t.Fatalf("Parse(%s) error: %s", name, err)
+		}
+		if !reflect.DeepEqual(list, want) {
+			t.Errorf("Parse(%s) = %+v, want: %+v", name, list, want)
+		}
+	}
+
+	for _, content := range []string{"", "features: []\n", "scenario:\n  - Invalid login\n", `{"tag": ["@flaky"]}`, `{"tags": "@flaky"}`} {
+		if _, err := Parse([]byte(content)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", content)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	scenario := cucumber.Scenario{
+		Element:     cucumber.Element{Name: "Invalid login", Line: 8, Tags: []cucumber.Tag{{Name: "@flaky"}}},
+		FeatureURI:  "features/login.feature",
+		FeatureTags: []cucumber.Tag{{Name: "@login"}},
+	}
+
+	for _, tt := range []struct {
+		list       List
+		wantReason string
+		wantMatch  bool
+	}{
+		{list: List{Features: []string{"features/login.feature"}}, wantReason: "feature features/login.feature", wantMatch: true},
+		{list: List{Features: []string{"features/login.feature:8"}}, wantReason: "feature features/login.feature:8", wantMatch: true},
+		// the feature paths may be relative to a different dir than the uri of the report
+		{list: List{Features: []string{"app/features/login.feature"}}, wantReason: "feature app/features/login.feature", wantMatch: true},
+		{list: List{Features: []string{"features/login.feature:3"}}},
+		{list: List{Features: []string{"login.feature.bak"}}},
+		{list: List{Scenarios: []string{"Invalid login"}}, wantReason: "scenario Invalid login", wantMatch: true},
+		{list: List{Scenarios: []string{"Invalid"}}},
+		{list: List{Tags: []string{"@flaky"}}, wantReason: "tag @flaky", wantMatch: true},
+		{list: List{Tags: []string{"@login"}}, wantReason: "tag @login", wantMatch: true},
+		{list: List{Tags: []string{"@wip"}}},
+	} {
+		reason, match := tt.list.Match(scenario)
+		if reason != tt.wantReason || match != tt.wantMatch {
+			t.Errorf("%+v.Match() = %q, %v, want: %q, %v", tt.list, reason, match, tt.wantReason, tt.wantMatch)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+)
+
+// outputPathWithSuffix inserts the suffix before the extension of a report path,
+// like: report.html -> report_shard1.html
+func outputPathWithSuffix(pth, suffix string) string {
+	ext := filepath.Ext(pth)
+	return strings.TrimSuffix(pth, ext) + "_" + suffix + ext
+}
+
+// optionsWithOutputSuffix returns the options with the suffix added to every --out path.
+func optionsWithOutputSuffix(options cucumber.Options, suffix string) cucumber.Options {
+	return options.WithOutputs(func(pth string) string {
+		return outputPathWithSuffix(pth, suffix)
+	})
+}
+
+// readRerunFile returns the scenario locations listed by cucumber's rerun formatter.
+func readRerunFile(pth string) ([]string, error) {
+	if exist, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if !exist {
+		return []string{}, nil
+	}
+
+	content, err := fileutil.ReadStringFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(content), nil
+}
+
+// calabashRun is a `calabash-android run` invocation, which reruns the failed scenarios up to retryCount times.
+type calabashRun struct {
+	calabash calabashAndroid
+	apkPth   string
+	envs     []string
+	options  cucumber.Options
+	features []string
+
+	jsonReportPth string
+	// screenshotDir is the SCREENSHOT_PATH of calabash
+	screenshotDir string
+	retryCount    int
+	timeouts      runTimeouts
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// attemptCommand returns the command of the given attempt, the first attempt is 0.
+func (run calabashRun) attemptCommand(attempt int, options cucumber.Options, args []string) (*command.Model, error) {
+	runOptions := options.Args()
+	if !options.HasFormat() {
+		// adding a formatter disables cucumber's default one, keep the console output
+		runOptions = append(runOptions, "--format", "pretty")
+	}
+	runOptions = append(runOptions, "--format", "json", "--out", run.attemptReportPth(attempt))
+	if run.retryCount > 0 {
+		runOptions = append(runOptions, "--format", "rerun", "--out", run.rerunPth(attempt))
+	}
+
+	cmdArgs := append([]string{"run", run.apkPth}, runOptions...)
+	cmdArgs = append(cmdArgs, args...)
+
+	envs := append([]string{}, run.envs...)
+	if run.screenshotDir != "" {
+		envs = append(envs, "SCREENSHOT_PATH="+run.screenshotPrefix(attempt))
+	}
+
+	return run.calabash.command(envs, cmdArgs...)
+}
+
+func (run calabashRun) attempt(attempt int, options cucumber.Options, args []string) error {
+	if reason, exceeded := run.timeouts.exceeded(); exceeded {
+		return timeoutError{reason: reason}
+	}
+
+	cmd, err := run.attemptCommand(attempt, options, args)
+	if err != nil {
+		return err
+	}
+	cmd.SetStdout(run.stdout).SetStderr(run.stderr)
+
+	if run.screenshotDir != "" {
+		if err := pathutil.EnsureDirExist(run.screenshotDir); err != nil {
+			return fmt.Errorf("failed to create screenshot dir, error: %s", err)
+		}
+	}
+
+	logCommand(cmd)
+	logger.Println()
+
+	if run.timeouts.enabled() {
+		err = newWatchdog(cmd, run.timeouts).run()
+	} else {
+		err = cmdExecutor.Run(cmd)
+	}
+
+	// the last line of the output may be unterminated
+	for _, w := range []io.Writer{run.stdout, run.stderr} {
+		if f, ok := w.(flusher); ok {
+			if ferr := f.Flush(); ferr != nil {
+				logger.Warnf("Failed to write the output of calabash-android, error: %s", ferr)
+			}
+		}
+	}
+
+	return err
+}
+
+// flusher is an output writer, which buffers the unterminated last line.
+type flusher interface {
+	Flush() error
+}
+
+func (run calabashRun) attemptReportPth(attempt int) string {
+	if attempt == 0 {
+		return run.jsonReportPth
+	}
+	return outputPathWithSuffix(run.jsonReportPth, "retry"+strconv.Itoa(attempt))
+}
+
+// screenshotPrefix returns the SCREENSHOT_PATH of the attempt, calabash prefixes the screenshot file names with it.
+// The reruns get their own prefix, the screenshot counter of calabash restarts on every run.
+func (run calabashRun) screenshotPrefix(attempt int) string {
+	if attempt == 0 {
+		return run.screenshotDir + string(filepath.Separator)
+	}
+	return filepath.Join(run.screenshotDir, "retry"+strconv.Itoa(attempt)+"_")
+}
+
+func (run calabashRun) rerunPth(attempt int) string {
+	return strings.TrimSuffix(run.jsonReportPth, filepath.Ext(run.jsonReportPth)) + "_rerun" + strconv.Itoa(attempt) + ".txt"
+}
+
+// unexplainedFailureError is the error of a run, whose failure is not explained by the failed scenarios of its report,
+// like a missing report, or a failure outside of the scenarios (a crash of the test server).
+type unexplainedFailureError struct {
+	reason string
+	err    error
+}
+
+func (err unexplainedFailureError) Error() string {
+	return err.err.Error()
+}
+
+func isUnexplainedFailure(err error) bool {
+	_, ok := err.(unexplainedFailureError)
+	return ok
+}
+
+// explainFailure returns the error of the failed run, marked as unexplained if the report has no failed scenario.
+func (run calabashRun) explainFailure(runErr error, features []cucumber.Feature) error {
+	summary := cucumber.NewSummary(features)
+	failed := summary.Failed
+	if isStrict(run.options) {
+		failed += summary.Undefined + summary.Pending
+	}
+	if failed == 0 {
+		return unexplainedFailureError{reason: "no failed scenario in the report " + run.jsonReportPth, err: runErr}
+	}
+	return runErr
+}
+
+// execute runs the tests and reruns the failed scenarios, the json report is updated with the rerun results.
+// It returns the scenarios which failed at first but passed on a rerun.
+// The error of a failed run is an unexplainedFailureError, if its failure is not explained by the failed scenarios of the report.
+func (run calabashRun) execute() ([]cucumber.Scenario, error) {
+	runErr := run.attempt(0, run.options, run.features)
+	if runErr == nil || isTimeout(runErr) {
+		return nil, runErr
+	}
+
+	features, err := cucumber.ParseReportFile(run.jsonReportPth)
+	if err != nil {
+		if run.retryCount > 0 {
+			logger.Warnf("Failed to parse cucumber json report (%s), skipping rerun, error: %s", run.jsonReportPth, err)
+		}
+		return nil, unexplainedFailureError{reason: fmt.Sprintf("failed to parse the report %s, error: %s", run.jsonReportPth, err), err: runErr}
+	}
+	if run.retryCount <= 0 {
+		return nil, run.explainFailure(runErr, features)
+	}
+
+	// rerunReportErr is set if the report of a rerun is missing, the merged report does not hold its results
+	var rerunReportErr error
+
+	flaky := []cucumber.Scenario{}
+	for attempt := 1; attempt <= run.retryCount; attempt++ {
+		failed, err := readRerunFile(run.rerunPth(attempt - 1))
+		if err != nil {
+			logger.Warnf("Failed to read rerun file, error: %s", err)
+			break
+		}
+		if len(failed) == 0 {
+			logger.Warnf("No failed scenario to rerun, the run failed for another reason")
+			break
+		}
+
+		logger.Println()
+		logger.Infof("Rerunning %d failed scenarios (%d/%d)...", len(failed), attempt, run.retryCount)
+
+		options := optionsWithOutputSuffix(run.options.WithoutFeaturePaths(), "retry"+strconv.Itoa(attempt))
+		runErr = run.attempt(attempt, options, []string{"@" + run.rerunPth(attempt-1)})
+
+		rerunFeatures, err := cucumber.ParseReportFile(run.attemptReportPth(attempt))
+		if err != nil {
+			logger.Warnf("Failed to parse cucumber json report of the rerun, error: %s", err)
+			rerunReportErr = fmt.Errorf("failed to parse the report %s, error: %s", run.attemptReportPth(attempt), err)
+			break
+		}
+
+		var fixed []cucumber.Scenario
+		features, fixed = cucumber.MergeRerun(features, rerunFeatures)
+		for _, scenario := range fixed {
+			logger.Warnf("Flaky scenario passed on rerun %d: %s (%s)", attempt, scenario.Name, scenario.Location())
+		}
+		flaky = append(flaky, fixed...)
+
+		if runErr == nil || isTimeout(runErr) {
+			break
+		}
+	}
+
+	if err := cucumber.WriteReportFile(run.jsonReportPth, features); err != nil {
+		logger.Warnf("Failed to write merged cucumber json report, error: %s", err)
+		rerunReportErr = fmt.Errorf("failed to write the merged report %s, error: %s", run.jsonReportPth, err)
+	}
+
+	if runErr == nil || isTimeout(runErr) {
+		return flaky, runErr
+	}
+	if rerunReportErr != nil {
+		return flaky, unexplainedFailureError{reason: rerunReportErr.Error(), err: runErr}
+	}
+	return flaky, run.explainFailure(runErr, features)
+}
@@ -0,0 +1,174 @@
+package screenshot
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+)
+
+// UnmatchedDir is the zip dir of the screenshots, which are not embedded in the cucumber report.
+const UnmatchedDir = "other"
+
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/jpg":  ".jpg",
+	"image/gif":  ".gif",
+}
+
+// Screenshot is a collected screenshot with its name in the zip.
+type Screenshot struct {
+	// Name is the path in the zip, like: Login/Invalid password_1.png
+	Name string
+	Data []byte
+}
+
+var unsafeNameCharacters = regexp.MustCompile(`[^\w\-. ]+`)
+
+// sanitize makes the feature or scenario name usable as a file name.
+func sanitize(name string) string {
+	name = strings.TrimSpace(unsafeNameCharacters.ReplaceAllString(name, "_"))
+	if name == "" {
+		return "unnamed"
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return name
+}
+
+func embeddedImages(scenario cucumber.Scenario) []cucumber.Embedding {
+	embeddings := []cucumber.Embedding{}
+	for _, hook := range scenario.Before {
+		embeddings = append(embeddings, hook.Embeddings...)
+	}
+	for _, step := range scenario.BackgroundSteps {
+		embeddings = append(embeddings, step.Embeddings...)
+	}
+	for _, step := range scenario.Steps {
+		embeddings = append(embeddings, step.Embeddings...)
+	}
+	for _, hook := range scenario.After {
+		embeddings = append(embeddings, hook.Embeddings...)
+	}
+
+	images := []cucumber.Embedding{}
+	for _, embedding := range embeddings {
+		if _, ok := imageExtensions[embedding.MimeType]; ok {
+			images = append(images, embedding)
+		}
+	}
+	return images
+}
+
+// Collect returns the screenshots embedded in the cucumber report, named by feature and scenario,
+// and the screenshots found in the dir, which are not embedded in the report.
+// calabash's screenshot_embed writes the screenshot into the dir and embeds the same image into the report.
+func Collect(dir string, features []cucumber.Feature) ([]Screenshot, error) {
+	screenshots := []Screenshot{}
+	embedded := map[[sha1.Size]byte]bool{}
+	names := map[string]bool{}
+
+	for _, feature := range features {
+		for _, scenario := range feature.Scenarios() {
+			for i, image := range embeddedImages(scenario) {
+				// the ruby base64 encoder breaks the lines
+				data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(image.Data), ""))
+				if err != nil {
+					return nil, fmt.Errorf("failed to decode screenshot of scenario (%s), error: %s", scenario.Location(), err)
+				}
+
+				name := fmt.Sprintf("%s/%s_%d%s", sanitize(scenario.FeatureName), sanitize(scenario.Name), i+1, imageExtensions[image.MimeType])
+				if names[name] {
+					// scenario outline examples have the same name
+					name = fmt.Sprintf("%s/%s_line%d_%d%s", sanitize(scenario.FeatureName), sanitize(scenario.Name), scenario.Line, i+1, imageExtensions[image.MimeType])
+				}
+				names[name] = true
+
+				embedded[sha1.Sum(data)] = true
+				screenshots = append(screenshots, Screenshot{Name: name, Data: data})
+			}
+		}
+	}
+
+	if exist, err := pathutil.IsDirExists(dir); err != nil {
+		return nil, err
+	} else if !exist {
+		return screenshots, nil
+	}
+
+	if err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isImage(pth) {
+			return nil
+		}
+
+		data, err := fileutil.ReadBytesFromFile(pth)
+		if err != nil {
+			return err
+		}
+		if embedded[sha1.Sum(data)] {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, pth)
+		if err != nil {
+			return err
+		}
+		screenshots = append(screenshots, Screenshot{Name: UnmatchedDir + "/" + filepath.ToSlash(rel), Data: data})
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to collect screenshots from %s, error: %s", dir, err)
+	}
+
+	return screenshots, nil
+}
+
+func isImage(pth string) bool {
+	switch strings.ToLower(filepath.Ext(pth)) {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return true
+	}
+	return false
+}
+
+// WriteZip writes the screenshots into a zip file.
+func WriteZip(pth string, screenshots []Screenshot) (err error) {
+	if err := pathutil.EnsureDirExist(filepath.Dir(pth)); err != nil {
+		return err
+	}
+
+	file, err := os.Create(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	writer := zip.NewWriter(file)
+	for _, screenshot := range screenshots {
+		// the images are already compressed
+		w, err := writer.CreateHeader(&zip.FileHeader{Name: screenshot.Name, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(screenshot.Data); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
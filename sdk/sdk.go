@@ -0,0 +1,229 @@
+package sdk
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/hashicorp/go-version"
+)
+
+// Tool names, which can dump the permissions of an apk.
+const (
+	AAPT        = "aapt"
+	AAPT2       = "aapt2"
+	APKAnalyzer = "apkanalyzer"
+)
+
+// Model ...
+type Model struct {
+	androidHome string
+}
+
+// New ...
+func New(androidHome string) Model {
+	return Model{androidHome: androidHome}
+}
+
+// BuildTools is an installed build-tools version.
+type BuildTools struct {
+	Version *version.Version
+	Dir     string
+}
+
+// installedBuildTools returns the build-tools versions in descending order,
+// directories with a name which is not a valid version are skipped, like preview (30.0.0-rc1) versions.
+func (sdk Model) installedBuildTools() ([]BuildTools, error) {
+	buildToolsDir := filepath.Join(sdk.androidHome, "build-tools")
+	infos, err := ioutil.ReadDir(buildToolsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	buildTools := []BuildTools{}
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+
+		ver, err := version.NewVersion(info.Name())
+		if err != nil || ver.Prerelease() != "" {
+			continue
+		}
+
+		buildTools = append(buildTools, BuildTools{Version: ver, Dir: filepath.Join(buildToolsDir, info.Name())})
+	}
+
+	sort.Slice(buildTools, func(i, j int) bool { return buildTools[j].Version.LessThan(buildTools[i].Version) })
+	return buildTools, nil
+}
+
+// BuildTools returns the build-tools of the given version, or the latest one if no version is given.
+func (sdk Model) BuildTools(pinnedVersion string) (BuildTools, error) {
+	if pinnedVersion != "" {
+		dir := filepath.Join(sdk.androidHome, "build-tools", pinnedVersion)
+		if exist, err := pathutil.IsDirExists(dir); err != nil {
+			return BuildTools{}, err
+		} else if !exist {
+			return BuildTools{}, fmt.Errorf("build-tools %s not installed at: %s", pinnedVersion, dir)
+		}
+
+		ver, err := version.NewVersion(pinnedVersion)
+		if err != nil {
+			return BuildTools{}, fmt.Errorf("invalid build-tools version (%s), error: %s", pinnedVersion, err)
+		}
+		return BuildTools{Version: ver, Dir: dir}, nil
+	}
+
+	buildTools, err := sdk.installedBuildTools()
+	if err != nil {
+		return BuildTools{}, err
+	}
+	if len(buildTools) == 0 {
+		return BuildTools{}, fmt.Errorf("no build-tools installed in: %s", filepath.Join(sdk.androidHome, "build-tools"))
+	}
+	return buildTools[0], nil
+}
+
+// Tool returns the path of the given build tool, like aapt or aapt2.
+func (buildTools BuildTools) Tool(name string) (string, error) {
+	pth := filepath.Join(buildTools.Dir, name)
+	if exist, err := pathutil.IsPathExists(pth); err != nil {
+		return "", err
+	} else if !exist {
+		return "", fmt.Errorf("%s not exists at: %s", name, pth)
+	}
+	return pth, nil
+}
+
+// APKAnalyzer returns the path of apkanalyzer, the cmdline-tools are preferred over the legacy tools package.
+func (sdk Model) APKAnalyzer() (string, error) {
+	candidates := []string{filepath.Join(sdk.androidHome, "cmdline-tools", "latest", "bin", APKAnalyzer)}
+
+	pattern := filepath.Join(sdk.androidHome, "cmdline-tools", "*", "bin", APKAnalyzer)
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+	candidates = append(candidates, matches...)
+
+	candidates = append(candidates, filepath.Join(sdk.androidHome, "tools", "bin", APKAnalyzer))
+
+	for _, pth := range candidates {
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return "", err
+		} else if exist {
+			return pth, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in: %s", APKAnalyzer, sdk.androidHome)
+}
+
+// PermissionTool is a tool, which can dump the permissions of an apk.
+type PermissionTool struct {
+	Name string
+	Path string
+}
+
+// PermissionTools returns the available permission dumping tools in the order of preference: aapt, aapt2, apkanalyzer.
+// It returns an error if the pinned build-tools version is not installed, instead of falling back to apkanalyzer.
+func (sdk Model) PermissionTools(buildToolsVersion string) ([]PermissionTool, error) {
+	tools := []PermissionTool{}
+	var errs []string
+
+	buildTools, err := sdk.BuildTools(buildToolsVersion)
+	if err != nil && buildToolsVersion != "" {
+		return nil, err
+	} else if err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		for _, name := range []string{AAPT, AAPT2} {
+			pth, err := buildTools.Tool(name)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			tools = append(tools, PermissionTool{Name: name, Path: pth})
+		}
+	}
+
+	if pth, err := sdk.APKAnalyzer(); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		tools = append(tools, PermissionTool{Name: APKAnalyzer, Path: pth})
+	}
+
+	if len(tools) == 0 {
+		return nil, fmt.Errorf("no tool found to dump apk permissions:\n%s", strings.Join(errs, "\n"))
+	}
+	return tools, nil
+}
+
+// Command returns the command, which dumps the permissions of the apk.
+func (tool PermissionTool) Command(apkPth string) *command.Model {
+	switch tool.Name {
+	case AAPT2:
+		return command.New(tool.Path, "dump", "permissions", apkPth)
+	case APKAnalyzer:
+		return command.New(tool.Path, "manifest", "permissions", apkPth)
+	default:
+		return command.New(tool.Path, "d", "permissions", apkPth)
+	}
+}
+
+// ParsePermissions returns the used permissions from the output of the tool's permission dump.
+//
+// aapt and aapt2 print lines like:
+//
+//	uses-permission: name='android.permission.INTERNET'
+//	uses-permission: android.permission.INTERNET (older aapt)
+//
+// apkanalyzer prints one permission per line.
+func (tool PermissionTool) ParsePermissions(out string) []string {
+	permissions := []string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if tool.Name == APKAnalyzer {
+			permissions = append(permissions, line)
+			continue
+		}
+
+		// uses-permission and uses-permission-sdk-23 entries, declared permissions (permission:) are not used ones
+		if !strings.HasPrefix(line, "uses-permission") {
+			continue
+		}
+		split := strings.SplitN(line, ":", 2)
+		if len(split) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(split[1])
+
+		if strings.HasPrefix(value, "name=") {
+			value = strings.TrimPrefix(value, "name=")
+			value = strings.TrimPrefix(value, "'")
+			if idx := strings.Index(value, "'"); idx != -1 {
+				value = value[:idx]
+			}
+		} else if fields := strings.Fields(value); len(fields) > 0 {
+			value = fields[0]
+		}
+
+		if value != "" {
+			permissions = append(permissions, value)
+		}
+	}
+
+	return permissions
+}
@@ -0,0 +1,173 @@
+package sdk
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+func createTestSDK(t *testing.T, dirs, files []string) string {
+	t.Helper()
+
+	androidHome := t.TempDir()
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(androidHome, dir), 0755); err != nil {
+			t.Fatalf("failed to create %s: %s", dir, err)
+		}
+	}
+	for _, file := range files {
+		pth := filepath.Join(androidHome, file)
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			t.Fatalf("failed to create %s: %s", filepath.Dir(file), err)
+		}
+		if err := fileutil.WriteStringToFile(pth, ""); err != nil {
+			t.Fatalf("failed to write %s: %s", file, err)
+		}
+	}
+	return androidHome
+}
+
+func TestInstalledBuildTools(t *testing.T) {
+	androidHome := createTestSDK(t,
+		[]string{"build-tools/29.0.2", "build-tools/30.0.3", "build-tools/30.0.0-rc1", "build-tools/4.4W", "build-tools/docs", "build-tools/28.0.3"},
+		[]string{"build-tools/31.0.0", "build-tools/.DS_Store"},
+	)
+
+	buildTools, err := New(androidHome).installedBuildTools()
+	if err != nil {
+		t.Fatalf("installedBuildTools() error: %s", err)
+	}
+
+	var versions []string
+	for _, bt := range buildTools {
+		versions = append(versions, filepath.Base(bt.Dir))
+	}
+	// the preview versions, the files and the dirs which are not versions are skipped
+	if want := []string{"30.0.3", "29.0.2", "28.0.3"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("installedBuildTools() = %v, want: %v", versions, want)
+	}
+
+	if _, err := New(t.TempDir()).installedBuildTools(); err == nil {
+		t.Errorf("installedBuildTools() succeeded without build-tools dir")
+	}
+}
+
+func TestBuildTools(t *testing.T) {
+	androidHome := createTestSDK(t, []string{"build-tools/29.0.2", "build-tools/30.0.3", "build-tools/30.0.0-rc1"}, nil)
+	sdk := New(androidHome)
+
+	for _, tt := range []struct {
+		pinned string
+		want   string
+	}{
+		{pinned: "", want: "30.0.3"},
+		{pinned: "29.0.2", want: "29.0.2"},
+		// a preview version is used if it is pinned
+		{pinned: "30.0.0-rc1", want: "30.0.0-rc1"},
+	} {
+		buildTools, err := sdk.BuildTools(tt.pinned)
+		if err != nil {
+			t.Fatalf("BuildTools(%s) error: %s", tt.pinned, err)
+		}
+		if dir := filepath.Base(buildTools.Dir); dir != tt.want {
+			t.Errorf("BuildTools(%s) = %s, want: %s", tt.pinned, dir, tt.want)
+		}
+	}
+
+	if _, err := sdk.BuildTools("28.0.3"); err == nil || !strings.Contains(err.Error(), "build-tools 28.0.3 not installed") {
+		t.Errorf("BuildTools(28.0.3) error = %v, want not installed", err)
+	}
+}
+
+func TestPermissionTools(t *testing.T) {
+	androidHome := createTestSDK(t, nil, []string{
+		"build-tools/29.0.2/aapt",
+		"build-tools/30.0.3/aapt2",
+		"cmdline-tools/latest/bin/apkanalyzer",
+	})
+	sdk := New(androidHome)
+
+	toolNames := func(tools []PermissionTool) []string {
+		var names []string
+		for _, tool := range tools {
+			names = append(names, tool.Name)
+		}
+		return names
+	}
+
+	tools, err := sdk.PermissionTools("")
+	if err != nil {
+		t.Fatalf("PermissionTools() error: %s", err)
+	}
+	// the latest build-tools has no aapt
+	if names := toolNames(tools); !reflect.DeepEqual(names, []string{AAPT2, APKAnalyzer}) {
+		t.Errorf("PermissionTools() = %v", names)
+	}
+
+	tools, err = sdk.PermissionTools("29.0.2")
+	if err != nil {
+		t.Fatalf("PermissionTools(29.0.2) error: %s", err)
+	}
+	if names := toolNames(tools); !reflect.DeepEqual(names, []string{AAPT, APKAnalyzer}) {
+		t.Errorf("PermissionTools(29.0.2) = %v", names)
+	}
+
+	// the pinned version does not fall back to apkanalyzer
+	if _, err := sdk.PermissionTools("28.0.3"); err == nil || !strings.Contains(err.Error(), "build-tools 28.0.3 not installed") {
+		t.Errorf("PermissionTools(28.0.3) error = %v, want not installed", err)
+	}
+}
+
+func TestParsePermissions(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		tool string
+		out  string
+		want []string
+	}{
+		{
+			name: "aapt",
+			tool: AAPT,
+			out: `package: com.example.app
+uses-permission: name='android.permission.INTERNET'
+uses-permission: name='android.permission.WRITE_EXTERNAL_STORAGE' maxSdkVersion='18'
+uses-permission-sdk-23: name='android.permission.CAMERA'
+permission: com.example.app.permission.C2D_MESSAGE
+`,
+			want: []string{"android.permission.INTERNET", "android.permission.WRITE_EXTERNAL_STORAGE", "android.permission.CAMERA"},
+		},
+		{
+			name: "aapt2",
+			tool: AAPT2,
+			out: `package: com.example.app
+permission: com.example.app.permission.C2D_MESSAGE
+uses-permission: android.permission.INTERNET
+uses-permission: android.permission.ACCESS_NETWORK_STATE
+`,
+			want: []string{"android.permission.INTERNET", "android.permission.ACCESS_NETWORK_STATE"},
+		},
+		{
+			name: "apkanalyzer",
+			tool: APKAnalyzer,
+			out: `android.permission.INTERNET
+android.permission.ACCESS_NETWORK_STATE
+
+`,
+			want: []string{"android.permission.INTERNET", "android.permission.ACCESS_NETWORK_STATE"},
+		},
+		{
+			name: "no permissions",
+			tool: AAPT,
+			out:  "package: com.example.app\n",
+			want: []string{},
+		},
+	} {
+		if permissions := (PermissionTool{Name: tt.tool}).ParsePermissions(tt.out); !reflect.DeepEqual(permissions, tt.want) {
+			t.Errorf("ParsePermissions(%s) = %v, want: %v", tt.name, permissions, tt.want)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/adb"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+)
+
+// defaultTestServerPort is the port calabash-android forwards to the test server if TEST_SERVER_PORT is not set.
+const defaultTestServerPort = 34777
+
+type shard struct {
+	index         int
+	device        adb.Device
+	features      []string
+	jsonReportPth string
+	screenshotDir string
+}
+
+// featureFiles returns the feature files to shard, relative to the work dir and in lexical order.
+// The given feature paths are sharded if there are any, the dirs among them are expanded to their feature files;
+// otherwise the feature files under the features dir of the work dir are sharded.
+func featureFiles(workDir string, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"features"}
+	}
+
+	unique := map[string]bool{}
+	for _, featurePth := range paths {
+		pth := featurePth
+		if !filepath.IsAbs(pth) {
+			pth = filepath.Join(workDir, pth)
+		}
+
+		if info, err := os.Stat(pth); err != nil || !info.IsDir() {
+			// a feature file, maybe with line numbers like features/login.feature:12, cucumber reports it if it is missing
+			unique[featurePth] = true
+			continue
+		}
+
+		if err := filepath.Walk(pth, func(pth string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || filepath.Ext(pth) != ".feature" {
+				return nil
+			}
+
+			rel, err := filepath.Rel(workDir, pth)
+			if err != nil {
+				return err
+			}
+			unique[rel] = true
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	features := []string{}
+	for feature := range unique {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return features, nil
+}
+
+// shardFeatures distributes the features round-robin into the given number of shards,
+// the same features and shard count always result in the same split.
+func shardFeatures(features []string, count int) [][]string {
+	shards := make([][]string, count)
+	for i, feature := range features {
+		shards[i%count] = append(shards[i%count], feature)
+	}
+	return shards
+}
+
+// newShards splits the features across the given devices, devices without features are left out.
+func newShards(devices []adb.Device, features []string, reportDir string) []shard {
+	sortedDevices := append([]adb.Device{}, devices...)
+	sort.Slice(sortedDevices, func(i, j int) bool { return sortedDevices[i].Serial < sortedDevices[j].Serial })
+
+	shards := []shard{}
+	for i, shardFeatures := range shardFeatures(features, len(sortedDevices)) {
+		if len(shardFeatures) == 0 {
+			continue
+		}
+
+		shards = append(shards, shard{
+			index:         i,
+			device:        sortedDevices[i],
+			features:      shardFeatures,
+			jsonReportPth: filepath.Join(reportDir, fmt.Sprintf("calabash-android_report_shard%d.json", i)),
+			screenshotDir: filepath.Join(screenshotDir(reportDir), fmt.Sprintf("shard%d", i)),
+		})
+	}
+	return shards
+}
+
+// prefixWriter prefixes every line written to the underlying writer, writes of parallel shards are serialized.
+type prefixWriter struct {
+	prefix string
+	writer io.Writer
+	mutex  *sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buffer.Write(p)
+	for {
+		line, err := w.buffer.ReadBytes('\n')
+		if err != nil {
+			// incomplete line, wait for the rest
+			w.buffer.Reset()
+			w.buffer.Write(line)
+			break
+		}
+
+		w.mutex.Lock()
+		_, werr := fmt.Fprintf(w.writer, "%s%s", w.prefix, line)
+		w.mutex.Unlock()
+		if werr != nil {
+			return 0, werr
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes out the last, unterminated line.
+func (w *prefixWriter) Flush() error {
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	_, err := fmt.Fprintf(w.writer, "%s%s\n", w.prefix, w.buffer.String())
+	w.buffer.Reset()
+	return err
+}
+
+// shardRun returns the run of the shard on its own device and test server port, without output writers.
+// The feature paths of the options are left out, the shard runs its own features only.
+func shardRun(calabash calabashAndroid, apkPth string, s shard, options cucumber.Options, retryCount int) calabashRun {
+	runOptions := optionsWithOutputSuffix(options.WithoutFeaturePaths(), "shard"+strconv.Itoa(s.index))
+	if len(options.Requires()) == 0 {
+		// support files are only loaded from the dirs of the given features, load the whole features dir
+		runOptions = append(runOptions, cucumber.Option{Name: "--require", Value: "features"})
+	}
+
+	return calabashRun{
+		calabash: calabash,
+		apkPth:   apkPth,
+		envs: []string{
+			"ADB_DEVICE_ARG=" + s.device.Serial,
+			"TEST_SERVER_PORT=" + strconv.Itoa(defaultTestServerPort+s.index),
+		},
+		options:       runOptions,
+		features:      s.features,
+		jsonReportPth: s.jsonReportPth,
+		screenshotDir: s.screenshotDir,
+		retryCount:    retryCount,
+	}
+}
+
+// runShards runs the shards in parallel, each on its own device and test server port.
+// It returns the flaky scenarios of all shards.
+func runShards(calabash calabashAndroid, apkPth string, shards []shard, options cucumber.Options, retryCount int, timeouts runTimeouts) ([]cucumber.Scenario, error) {
+	var wg sync.WaitGroup
+	var outputMutex sync.Mutex
+	errs := make([]error, len(shards))
+	flakyByShard := make([][]cucumber.Scenario, len(shards))
+
+	for i, s := range shards {
+		prefix := fmt.Sprintf("[%s] ", s.device.Serial)
+		if logger.IsJSON() {
+			// the device is a field of the output events
+			prefix = ""
+		}
+		fields := logger.Fields{"device": s.device.Serial}
+		stderrFields := logger.Fields{"device": s.device.Serial, "stream": "stderr"}
+		stdout := &prefixWriter{prefix: prefix, writer: logger.NewOutputWriter("calabash-android", os.Stdout, fields), mutex: &outputMutex}
+		stderr := &prefixWriter{prefix: prefix, writer: logger.NewOutputWriter("calabash-android", os.Stderr, stderrFields), mutex: &outputMutex}
+
+		run := shardRun(calabash, apkPth, s, options, retryCount)
+		run.timeouts = timeouts
+		run.stdout = stdout
+		run.stderr = stderr
+
+		logger.Printf("shard %d on %s (%d features)", s.index, s.device.Serial, len(s.features))
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			flakyByShard[i], errs[i] = run.execute()
+		}(i)
+	}
+
+	wg.Wait()
+
+	flaky := []cucumber.Scenario{}
+	for _, shardFlaky := range flakyByShard {
+		flaky = append(flaky, shardFlaky...)
+	}
+
+	failed := []string{}
+	timedOut := []string{}
+	unexplained := []string{}
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("shard %d on %s: %s", shards[i].index, shards[i].device.Serial, err))
+		}
+		switch err := err.(type) {
+		case timeoutError:
+			timedOut = append(timedOut, fmt.Sprintf("shard %d: %s", shards[i].index, err.reason))
+		case unexplainedFailureError:
+			unexplained = append(unexplained, fmt.Sprintf("shard %d: %s", shards[i].index, err.reason))
+		}
+	}
+	if len(failed) == 0 {
+		return flaky, nil
+	}
+
+	err := fmt.Errorf("%d of %d shards failed:\n%s", len(failed), len(shards), strings.Join(failed, "\n"))
+	if len(timedOut) > 0 {
+		return flaky, timeoutError{reason: strings.Join(timedOut, ", "), err: err}
+	}
+	if len(unexplained) > 0 {
+		return flaky, unexplainedFailureError{reason: strings.Join(unexplained, ", "), err: err}
+	}
+	return flaky, err
+}
+
+// mergeReports merges the cucumber json reports of the shards into one report.
+// The parsed reports are merged even if some of them are missing or invalid, those are reported in the error.
+func mergeReports(reportPths []string, mergedReportPth string) error {
+	merged := []cucumber.Feature{}
+	invalid := []string{}
+	for _, pth := range reportPths {
+		features, err := cucumber.ParseReportFile(pth)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %s", pth, err))
+			continue
+		}
+		merged = append(merged, features...)
+	}
+
+	if err := cucumber.WriteReportFile(mergedReportPth, merged); err != nil {
+		return err
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("failed to parse %d of %d shard reports:\n%s", len(invalid), len(reportPths), strings.Join(invalid, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/adb"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+)
+
+func TestShardFeatures(t *testing.T) {
+	features := []string{"features/a.feature", "features/b.feature", "features/c.feature", "features/d.feature", "features/e.feature"}
+
+	want := [][]string{
+		{"features/a.feature", "features/c.feature", "features/e.feature"},
+		{"features/b.feature", "features/d.feature"},
+	}
+	for i := 0; i < 3; i++ {
+		if got := shardFeatures(features, 2); !reflect.DeepEqual(got, want) {
+			t.Fatalf("shardFeatures() = %v, want: %v", got, want)
+		}
+	}
+
+	if got := shardFeatures(features[:1], 3); len(got) != 3 || len(got[0]) != 1 || len(got[1]) != 0 || len(got[2]) != 0 {
+		t.Fatalf("shardFeatures() = %v, want the feature in the first shard only", got)
+	}
+}
+
+func TestFeatureFiles(t *testing.T) {
+	workDir := t.TempDir()
+	for _, pth := range []string{"features/login.feature", "features/search/basic.feature", "features/search/steps.rb", "smoke/checkout.feature"} {
+		writeTestFile(t, filepath.Join(workDir, pth), "")
+	}
+
+	features, err := featureFiles(workDir, nil)
+	if err != nil {
+		t.Fatalf("featureFiles() error: %s", err)
+	}
+	if want := []string{"features/login.feature", "features/search/basic.feature"}; !reflect.DeepEqual(features, want) {
+		t.Errorf("featureFiles() = %v, want: %v", features, want)
+	}
+
+	// only the given feature paths are sharded, the dirs are expanded
+	features, err = featureFiles(workDir, []string{"smoke", "features/login.feature:12", "features/search/basic.feature"})
+	if err != nil {
+		t.Fatalf("featureFiles() error: %s", err)
+	}
+	if want := []string{"features/login.feature:12", "features/search/basic.feature", "smoke/checkout.feature"}; !reflect.DeepEqual(features, want) {
+		t.Errorf("featureFiles() = %v, want: %v", features, want)
+	}
+}
+
+func TestNewShards(t *testing.T) {
+	reportDir := t.TempDir()
+	devices := []adb.Device{{Serial: "emulator-5558"}, {Serial: "emulator-5554"}, {Serial: "emulator-5556"}}
+
+	shards := newShards(devices, []string{"features/a.feature", "features/b.feature"}, reportDir)
+
+	// the devices are sorted by serial, the device without features is left out
+	if len(shards) != 2 {
+		t.Fatalf("shards: %d, want: 2", len(shards))
+	}
+	for i, want := range []struct {
+		serial  string
+		feature string
+	}{
+		{serial: "emulator-5554", feature: "features/a.feature"},
+		{serial: "emulator-5556", feature: "features/b.feature"},
+	} {
+		s := shards[i]
+		if s.index != i || s.device.Serial != want.serial || !reflect.DeepEqual(s.features, []string{want.feature}) {
+			t.Errorf("shard %d = %d on %s with %v, want: %s with %s", i, s.index, s.device.Serial, s.features, want.serial, want.feature)
+		}
+		if wantPth := filepath.Join(reportDir, "calabash-android_report_shard"+strconv.Itoa(i)+".json"); s.jsonReportPth != wantPth {
+			t.Errorf("shard %d report = %s, want: %s", i, s.jsonReportPth, wantPth)
+		}
+	}
+
+	if shards := newShards(devices, nil, reportDir); len(shards) != 0 {
+		t.Errorf("shards without features = %d, want: 0", len(shards))
+	}
+}
+
+func TestRunShards(t *testing.T) {
+	fake := withFakeExecutor(t)
+
+	reportDir := t.TempDir()
+	shards := newShards([]adb.Device{{Serial: "emulator-5554"}, {Serial: "emulator-5556"}}, []string{"features/a.feature", "features/b.feature"}, reportDir)
+	calabash := calabashAndroid{version: "0.9.8", workDir: t.TempDir()}
+	// the feature path of the options is sharded, the shards run their own features only
+	options := cucumber.Options{{Name: "--tags", Value: "@smoke"}, {Value: "features"}}
+
+	runCommand := func(s shard) string {
+		return "calabash-android _0.9.8_ run app.apk --tags @smoke --require features --format pretty --format json --out " + s.jsonReportPth + " " + s.features[0]
+	}
+	fake.respond(strings.TrimSuffix(runCommand(shards[1]), " "+shards[1].features[0]), "", errors.New("exit status 1"))
+
+	_, err := runShards(calabash, "app.apk", shards, options, 0, runTimeouts{})
+	if err == nil {
+		t.Fatalf("runShards() succeeded, want the failure of the second shard")
+	}
+	if !strings.HasPrefix(err.Error(), "1 of 2 shards failed") || !strings.Contains(err.Error(), "shard 1 on emulator-5556") {
+		t.Errorf("runShards() error = %s", err)
+	}
+
+	// the shards run in parallel, in any order
+	got := fake.commands()
+	sort.Strings(got)
+	want := []string{runCommand(shards[0]), runCommand(shards[1])}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("commands:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	envs := map[string]bool{}
+	for _, inv := range fake.invocations {
+		envs[strings.Join(inv.envs, " ")] = true
+	}
+	for _, want := range []string{
+		"ADB_DEVICE_ARG=emulator-5554 TEST_SERVER_PORT=34777 SCREENSHOT_PATH=" + shards[0].screenshotDir + "/",
+		"ADB_DEVICE_ARG=emulator-5556 TEST_SERVER_PORT=34778 SCREENSHOT_PATH=" + shards[1].screenshotDir + "/",
+	} {
+		if !envs[want] {
+			t.Errorf("no shard run with envs %s, got: %v", want, envs)
+		}
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	dir := t.TempDir()
+	shardReportPths := []string{filepath.Join(dir, "report_shard0.json"), filepath.Join(dir, "report_shard1.json")}
+	writeTestFile(t, shardReportPths[0], testCucumberReport)
+	writeTestFile(t, shardReportPths[1], `[{"uri":"features/search.feature","name":"Search","elements":[
+{"type":"scenario","name":"Search by name","line":3,"steps":[{"keyword":"When ","name":"I search","result":{"status":"passed","duration":1000000000}}]}
+]}]`)
+
+	mergedPth := filepath.Join(dir, "report.json")
+	if err := mergeReports(shardReportPths, mergedPth); err != nil {
+		t.Fatalf("mergeReports() error: %s", err)
+	}
+
+	features, err := cucumber.ParseReportFile(mergedPth)
+	if err != nil {
+		t.Fatalf("merged report: %s", err)
+	}
+	if summary := cucumber.NewSummary(features); len(features) != 2 || summary.Passed != 2 || summary.Failed != 1 {
+		t.Errorf("merged report: %d features, %+v", len(features), summary)
+	}
+}
+
+func TestMergeReportsMissingReport(t *testing.T) {
+	dir := t.TempDir()
+	shardReportPths := []string{filepath.Join(dir, "report_shard0.json"), filepath.Join(dir, "report_shard1.json")}
+	writeTestFile(t, shardReportPths[0], testCucumberReport)
+
+	mergedPth := filepath.Join(dir, "report.json")
+	err := mergeReports(shardReportPths, mergedPth)
+	if err == nil || !strings.Contains(err.Error(), shardReportPths[1]) {
+		t.Fatalf("mergeReports() error = %v, want the missing report", err)
+	}
+
+	// the parsed reports are merged anyway
+	features, err := cucumber.ParseReportFile(mergedPth)
+	if err != nil {
+		t.Fatalf("merged report: %s", err)
+	}
+	if len(features) != 1 {
+		t.Errorf("merged report: %d features, want: 1", len(features))
+	}
+}
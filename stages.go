@@ -0,0 +1,883 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/adb"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/gemfilelock"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/gems"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/quarantine"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// Stage names of the step's pipeline.
+const (
+	stageValidate     = "validate"
+	stageInspectAPK   = "inspect apk"
+	stageSelectDevice = "select device"
+	stageWaitForBoot  = "wait for boot"
+	stageResolveGem   = "resolve gem"
+	stageInstallGem   = "install gem"
+	stageKeystore     = "keystore"
+	stageResign       = "resign"
+	stageRun          = "run"
+	stageReport       = "report"
+)
+
+// stepInputs are the validated and expanded inputs, the output of the validate stage.
+type stepInputs struct {
+	workDir     string
+	gemFilePath string
+	options     cucumber.Options
+	retryCount  int
+
+	// zero timeouts mean no limit
+	runTimeout        time.Duration
+	inactivityTimeout time.Duration
+
+	captureLogcat bool
+	logcatAppOnly bool
+	// failOnCrash fails the step if the app crashed during the run, even if the tests passed
+	failOnCrash bool
+
+	// bootTimeout is the time to wait for the devices to come online and boot, zero means no waiting
+	bootTimeout time.Duration
+
+	// the json report is always generated, it is the source of the test summary
+	reportDir      string
+	jsonReportPth  string
+	junitReportPth string
+
+	// maxPrintedFailures limits the failed scenarios printed from the html reports, zero means no limit
+	maxPrintedFailures int
+
+	// quarantine lists the scenarios, whose failures do not fail the run, nil if no list is given
+	quarantine *quarantine.List
+}
+
+func validateInputs(configs ConfigsModel) (stepInputs, error) {
+	if err := configs.validate(); err != nil {
+		return stepInputs{}, fmt.Errorf("issue with input: %s", err)
+	}
+
+	workDir, err := pathutil.AbsPath(configs.WorkDir)
+	if err != nil {
+		return stepInputs{}, fmt.Errorf("failed to expand WorkDir (%s), error: %s", configs.WorkDir, err)
+	}
+
+	gemFilePath := ""
+	if configs.GemFilePath != "" {
+		gemFilePath, err = pathutil.AbsPath(configs.GemFilePath)
+		if err != nil {
+			return stepInputs{}, fmt.Errorf("failed to expand GemFilePath (%s), error: %s", configs.GemFilePath, err)
+		}
+	}
+
+	args, err := shellquote.Split(configs.Options)
+	if err != nil {
+		return stepInputs{}, fmt.Errorf("failed to split additional options (%s), error: %s", configs.Options, err)
+	}
+	options, err := cucumber.ParseOptions(args)
+	if err != nil {
+		return stepInputs{}, fmt.Errorf("issue with input: invalid additional options (%s), error: %s", configs.Options, err)
+	}
+	if err := checkProfiles(workDir, options.Profiles()); err != nil {
+		return stepInputs{}, fmt.Errorf("issue with input: invalid additional options (%s), error: %s", configs.Options, err)
+	}
+
+	retryCount := 0
+	if configs.RetryFailedCount != "" {
+		retryCount, err = strconv.Atoi(configs.RetryFailedCount)
+		if err != nil {
+			return stepInputs{}, fmt.Errorf("failed to parse RetryFailedCount (%s), error: %s", configs.RetryFailedCount, err)
+		}
+	}
+
+	maxPrintedFailures := 0
+	if configs.MaxPrintedFailures != "" {
+		maxPrintedFailures, err = strconv.Atoi(configs.MaxPrintedFailures)
+		if err != nil {
+			return stepInputs{}, fmt.Errorf("failed to parse MaxPrintedFailures (%s), error: %s", configs.MaxPrintedFailures, err)
+		}
+	}
+
+	var quarantineList *quarantine.List
+	if configs.QuarantineFile != "" {
+		list, err := quarantine.ReadFile(configs.QuarantineFile)
+		if err != nil {
+			return stepInputs{}, fmt.Errorf("issue with input: invalid quarantine file (%s), error: %s", configs.QuarantineFile, err)
+		}
+		quarantineList = &list
+	}
+
+	runTimeout, err := timeoutInput(configs.RunTimeout)
+	if err != nil {
+		return stepInputs{}, fmt.Errorf("failed to parse RunTimeout (%s), error: %s", configs.RunTimeout, err)
+	}
+	inactivityTimeout, err := timeoutInput(configs.InactivityTimeout)
+	if err != nil {
+		return stepInputs{}, fmt.Errorf("failed to parse InactivityTimeout (%s), error: %s", configs.InactivityTimeout, err)
+	}
+	bootTimeout, err := timeoutInput(configs.BootTimeout)
+	if err != nil {
+		return stepInputs{}, fmt.Errorf("failed to parse BootTimeout (%s), error: %s", configs.BootTimeout, err)
+	}
+
+	// in dry run mode a fixed dir is planned, so that the plans can be diffed
+	reportDir := filepath.Join(os.TempDir(), "calabash-android")
+	if configs.DryRun != "yes" {
+		reportDir, err = pathutil.NormalizedOSTempDirPath("calabash-android")
+		if err != nil {
+			return stepInputs{}, fmt.Errorf("failed to create report dir, error: %s", err)
+		}
+	}
+
+	return stepInputs{
+		workDir:            workDir,
+		gemFilePath:        gemFilePath,
+		options:            options,
+		retryCount:         retryCount,
+		runTimeout:         runTimeout,
+		inactivityTimeout:  inactivityTimeout,
+		captureLogcat:      configs.CaptureLogcat == "yes",
+		logcatAppOnly:      configs.LogcatAppOnly == "yes",
+		failOnCrash:        configs.FailOnCrash == "yes",
+		bootTimeout:        bootTimeout,
+		reportDir:          reportDir,
+		jsonReportPth:      filepath.Join(reportDir, "calabash-android_report.json"),
+		junitReportPth:     junitReportPath(configs.JUnitReportPath),
+		maxPrintedFailures: maxPrintedFailures,
+		quarantine:         quarantineList,
+	}, nil
+}
+
+// profileConfigPaths are the paths of the cucumber profiles config, relative to the work dir, in the order cucumber looks for them.
+var profileConfigPaths = []string{"cucumber.yml", "cucumber.yaml", ".config/cucumber.yml", ".config/cucumber.yaml", "config/cucumber.yml", "config/cucumber.yaml"}
+
+// checkProfiles ensures the profiles config of cucumber exists, if profiles are given.
+// The config (YAML with ERB) is not read, the formatters of the profiles are not located as reports.
+func checkProfiles(workDir string, profiles []string) error {
+	if len(profiles) == 0 {
+		return nil
+	}
+
+	for _, pth := range profileConfigPaths {
+		if exist, err := pathutil.IsPathExists(filepath.Join(workDir, pth)); err != nil {
+			return err
+		} else if exist {
+			logger.Printf("Using profiles: %s, from: %s", strings.Join(profiles, ", "), pth)
+			logger.Warnf("The reports of the formatters defined in the profiles are not exported")
+			return nil
+		}
+	}
+	return fmt.Errorf("--profile %s given, but no cucumber.yml found in the work dir (%s)", profiles[0], workDir)
+}
+
+// timeoutInput parses a timeout input given in seconds, the empty input means no timeout.
+func timeoutInput(seconds string) (time.Duration, error) {
+	if seconds == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(seconds)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(value) * time.Second, nil
+}
+
+// devicePollInterval is the interval of polling the devices while waiting for them to come online and boot.
+var devicePollInterval = 5 * time.Second
+
+// selectDevices returns the device to run the tests on,
+// or the devices to run the shards on if parallel run is requested and at least two devices are online.
+// The emulator of a previous step may still be starting, the devices are polled up to the wait timeout.
+func selectDevices(androidHome, serial string, parallelRun bool, waitTimeout time.Duration, plan *executionPlan) (adb.Device, []adb.Device, error) {
+	adbTool, err := adb.New(androidHome)
+	if err != nil {
+		return adb.Device{}, nil, fmt.Errorf("failed to find adb, error: %s", err)
+	}
+
+	deadline := time.Now().Add(waitTimeout)
+	waiting := false
+	for {
+		device, parallelDevices, err := selectOnlineDevices(adbTool, serial, parallelRun, plan)
+		if err == nil || plan != nil || !time.Now().Before(deadline) {
+			return device, parallelDevices, err
+		}
+
+		if !waiting {
+			logger.Warnf("%s", err)
+			logger.Printf("waiting up to %s for the device to come online...", waitTimeout)
+			waiting = true
+		}
+		time.Sleep(devicePollInterval)
+	}
+}
+
+func selectOnlineDevices(adbTool *adb.Model, serial string, parallelRun bool, plan *executionPlan) (adb.Device, []adb.Device, error) {
+	devicesCmd := adbTool.Command("devices", "-l")
+	out, err := cmdExecutor.RunAndReturnTrimmedCombinedOutput(devicesCmd)
+	if err != nil {
+		return adb.Device{}, nil, fmt.Errorf("failed to list devices, %s failed, output: %s, error: %s", devicesCmd.PrintableCommandArgs(), out, err)
+	}
+	devices := adb.ParseDevices(out)
+
+	if parallelRun {
+		parallelDevices := adb.OnlineDevices(devices)
+		if len(parallelDevices) >= 2 {
+			logger.Donef("using %d devices in parallel:\n%s", len(parallelDevices), adb.DeviceList(parallelDevices))
+
+			if plan != nil {
+				for _, d := range parallelDevices {
+					plan.Devices = append(plan.Devices, d.Serial)
+				}
+			}
+			return adb.Device{}, parallelDevices, nil
+		}
+
+		if len(parallelDevices) > 0 {
+			// without any online device the selection fails below
+			logger.Warnf("Parallel run requested, but %d online device found, running on a single device", len(parallelDevices))
+		}
+	}
+
+	device, err := selectDevice(devices, serial)
+	if err != nil && plan != nil {
+		// the plan is still useful without a device
+		logger.Warnf("Failed to select device, error: %s", err)
+		plan.addNote("no device selected: %s", err)
+		return adb.Device{}, nil, nil
+	} else if err != nil {
+		return adb.Device{}, nil, fmt.Errorf("failed to select device, error: %s", err)
+	}
+
+	logger.Donef("using device: %s", device)
+
+	if plan != nil {
+		plan.Devices = append(plan.Devices, device.Serial)
+	}
+	return device, nil, nil
+}
+
+// bootState returns whether the device has finished booting, and its boot properties.
+func bootState(adbTool *adb.Model, serial string) (bool, string, error) {
+	props := map[string]string{}
+	for _, prop := range []string{"sys.boot_completed", "init.svc.bootanim"} {
+		cmd := adbTool.WithSerial(serial).Command("shell", "getprop", prop)
+		out, err := cmdExecutor.RunAndReturnTrimmedCombinedOutput(cmd)
+		if err != nil {
+			return false, "", fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+		}
+		props[prop] = out
+	}
+
+	state := fmt.Sprintf("sys.boot_completed: %q, init.svc.bootanim: %q", props["sys.boot_completed"], props["init.svc.bootanim"])
+	// devices without boot animation have no bootanim service
+	booted := props["sys.boot_completed"] == "1" && (props["init.svc.bootanim"] == "stopped" || props["init.svc.bootanim"] == "")
+	return booted, state, nil
+}
+
+// waitForBoot waits for the devices to finish booting, up to the timeout.
+func waitForBoot(androidHome string, devices []adb.Device, timeout time.Duration) error {
+	adbTool, err := adb.New(androidHome)
+	if err != nil {
+		return fmt.Errorf("failed to find adb, error: %s", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for _, device := range devices {
+		waiting := false
+		for {
+			booted, state, err := bootState(adbTool, device.Serial)
+			if err != nil {
+				// the device may restart adbd while booting
+				state = err.Error()
+			}
+			if booted {
+				logger.Donef("%s booted", device.Serial)
+				break
+			}
+			if !time.Now().Before(deadline) {
+				return fmt.Errorf("%s did not boot in %s, %s", device.Serial, timeout, state)
+			}
+
+			if !waiting {
+				logger.Printf("waiting up to %s for %s to boot (%s)...", timeout, device.Serial, state)
+				waiting = true
+			}
+			time.Sleep(devicePollInterval)
+		}
+	}
+	return nil
+}
+
+// gemSetup is how calabash-android is installed and run, the output of the resolve gem stage.
+type gemSetup struct {
+	// version is the exact version to install and run, empty if bundler or the latest version is used
+	version     string
+	useBundler  bool
+	gemFilePath string
+	// lockedVersion is the version in the Gemfile.lock
+	lockedVersion string
+}
+
+// resolveCalabashAndroid determines the calabash-android version from the version input and the Gemfile.lock.
+func resolveCalabashAndroid(versionInput, gemFilePath string) (gemSetup, error) {
+	setup := gemSetup{gemFilePath: gemFilePath}
+
+	if gemFilePath != "" {
+		if exist, err := pathutil.IsPathExists(gemFilePath); err != nil {
+			return gemSetup{}, fmt.Errorf("failed to check if Gemfile exists at (%s) exist, error: %s", gemFilePath, err)
+		} else if exist {
+			logger.Printf("Gemfile exists at: %s", gemFilePath)
+
+			gemfileDir := filepath.Dir(gemFilePath)
+			gemfileLockPth := filepath.Join(gemfileDir, "Gemfile.lock")
+
+			if exist, err := pathutil.IsPathExists(gemfileLockPth); err != nil {
+				return gemSetup{}, fmt.Errorf("failed to check if Gemfile.lock exists at (%s), error: %s", gemfileLockPth, err)
+			} else if exist {
+				logger.Printf("Gemfile.lock exists at: %s", gemfileLockPth)
+
+				lockfile, err := gemfilelock.ParseFile(gemfileLockPth)
+				if err != nil {
+					return gemSetup{}, fmt.Errorf("failed to parse Gemfile.lock, error: %s", err)
+				}
+
+				if spec, source, found := lockfile.Spec("calabash-android"); found {
+					setup.lockedVersion = spec.Version
+
+					logger.Printf("calabash-android version in Gemfile.lock: %s", spec.Version)
+					logger.Printf("calabash-android source in Gemfile.lock: %s", source)
+				} else {
+					logger.Warnf("calabash-android not found in Gemfile.lock")
+				}
+
+				setup.useBundler = true
+			} else {
+				logger.Warnf("Gemfile.lock doest no find with calabash-android gem at: %s", gemfileLockPth)
+			}
+		} else {
+			logger.Warnf("Gemfile doest no find with calabash-android gem at: %s", gemFilePath)
+		}
+	}
+
+	setup.version = versionInput
+	if setup.version != "" && gems.IsConstraint(setup.version) {
+		logger.Printf("resolving calabash-android version constraint: %s", setup.version)
+
+		resolved, err := resolveCalabashAndroidVersion(setup.version)
+		if err != nil {
+			return gemSetup{}, fmt.Errorf("failed to resolve calabash-android version, error: %s", err)
+		}
+		setup.version = resolved
+	}
+
+	if setup.version != "" {
+		logger.Donef("using calabash-android version: %s", setup.version)
+	} else if setup.useBundler {
+		logger.Donef("using calabash-android with bundler")
+	} else {
+		logger.Donef("using calabash-android latest version")
+	}
+
+	return setup, nil
+}
+
+// calabash returns the calabash-android command factory of the gem setup.
+func (setup gemSetup) calabash(workDir string) calabashAndroid {
+	return calabashAndroid{
+		version:     setup.version,
+		useBundler:  setup.useBundler,
+		gemFilePath: setup.gemFilePath,
+		workDir:     workDir,
+	}
+}
+
+// installCalabashAndroid installs calabash-android and returns the installed version, if it can be determined.
+func installCalabashAndroid(setup gemSetup, plan *executionPlan) (string, error) {
+	if setup.version != "" {
+		installed, err := isCalabashAndroidInstalled(setup.version)
+		if err != nil {
+			return "", fmt.Errorf("failed to check if calabash-android (v%s) installed, error: %s", setup.version, err)
+		}
+
+		if !installed {
+			installCommands, err := cmdExecutor.NewGemInstallCommands("calabash-android", setup.version)
+			if err != nil {
+				return "", fmt.Errorf("failed to create gem install commands, error: %s", err)
+			}
+
+			for _, installCommand := range installCommands {
+				if err := runCommand(plan, planStageInstall, installCommand); err != nil {
+					return "", fmt.Errorf("command failed, error: %s", err)
+				}
+			}
+		} else {
+			logger.Printf("calabash-android %s installed", setup.version)
+		}
+	} else if setup.useBundler {
+		bundleInstallCmd, err := cmdExecutor.NewRubyCommand("bundle", "install", "--jobs", "20", "--retry", "5")
+		if err != nil {
+			return "", fmt.Errorf("failed to create command, error: %s", err)
+		}
+
+		bundleInstallCmd.AppendEnvs("BUNDLE_GEMFILE=" + setup.gemFilePath)
+
+		if err := runCommand(plan, planStageInstall, bundleInstallCmd); err != nil {
+			return "", fmt.Errorf("bundle install failed, error: %s", err)
+		}
+	} else {
+		installCommands, err := cmdExecutor.NewGemInstallCommands("calabash-android", "")
+		if err != nil {
+			return "", fmt.Errorf("failed to create gem install commands, error: %s", err)
+		}
+
+		for _, installCommand := range installCommands {
+			if err := runCommand(plan, planStageInstall, installCommand); err != nil {
+				return "", fmt.Errorf("command failed, error: %s", err)
+			}
+		}
+	}
+
+	resolvedVersion := setup.version
+	if setup.useBundler && resolvedVersion == "" {
+		// bundler runs the version locked in the Gemfile.lock
+		resolvedVersion = setup.lockedVersion
+	} else if resolvedVersion == "" && plan != nil {
+		plan.addNote("the latest calabash-android version would be installed")
+	} else if resolvedVersion == "" {
+		latest, err := latestInstalledCalabashAndroidVersion()
+		if err != nil {
+			logger.Warnf("Failed to determine the installed calabash-android version, error: %s", err)
+		}
+		resolvedVersion = latest
+	}
+
+	if plan != nil {
+		plan.CalabashAndroidVersion = resolvedVersion
+		plan.UseBundler = setup.useBundler && setup.version == ""
+	} else if resolvedVersion != "" {
+		logger.Donef("resolved calabash-android version: %s", resolvedVersion)
+	}
+
+	return resolvedVersion, nil
+}
+
+// prepareKeystore writes the configured keystore into the calabash settings, or ensures a debug keystore exists.
+// The returned function restores the original calabash settings, it is nil if the settings were not changed.
+func prepareKeystore(configs ConfigsModel, workDir string, plan *executionPlan) (func() error, error) {
+	if configs.KeystoreURL != "" {
+		keystore, err := newKeystoreConfig(configs)
+		if err != nil {
+			return nil, fmt.Errorf("issue with keystore input: %s", err)
+		}
+
+		if err := keystore.validate(); err != nil {
+			return nil, fmt.Errorf("issue with keystore input: %s", err)
+		}
+
+		var restore func() error
+		if plan != nil {
+			plan.Keystore = keystore.Path
+			plan.addNote("the keystore config would be written to: %s", filepath.Join(workDir, calabashSettingsFileName))
+		} else {
+			restore, err = writeCalabashSettings(workDir, keystore)
+			if err != nil {
+				return nil, fmt.Errorf("failed to write calabash settings, error: %s", err)
+			}
+		}
+
+		logger.Donef("using keystore: %s (alias: %s)", keystore.Path, keystore.Alias)
+		return restore, nil
+	}
+
+	logger.Printf("no keystore configured, searching for debug.keystore")
+
+	if plan != nil {
+		pth, err := findDebugKeystore()
+		if err != nil {
+			return nil, err
+		}
+		if pth == "" {
+			pth = androidDebugKeystorePath()
+			plan.addNote("a debug keystore would be generated at: %s", pth)
+		}
+		plan.Keystore = pth
+		return nil, nil
+	}
+
+	_, err := ensureDebugKeystore()
+	return nil, err
+}
+
+func resignAPK(calabash calabashAndroid, apkPth string, plan *executionPlan) error {
+	resignCmd, err := calabash.command(nil, "resign", apkPth)
+	if err != nil {
+		return fmt.Errorf("failed to create command, error: %s", err)
+	}
+
+	if err := runCommand(plan, planStageResign, resignCmd); err != nil {
+		return fmt.Errorf("failed to run command, error: %s", err)
+	}
+	return nil
+}
+
+// testRun is the input of the run stage.
+type testRun struct {
+	calabash        calabashAndroid
+	apkPth          string
+	device          adb.Device
+	parallelDevices []adb.Device
+	inputs          stepInputs
+
+	// androidHome and packageName are used to capture the logcat of the app
+	androidHome string
+	packageName string
+}
+
+// testRunResult is the output of the run stage.
+type testRunResult struct {
+	flaky []cucumber.Scenario
+	// reports are the reports of the formatters of additional_options written to files,
+	// the reports of every shard and rerun in parallel mode and with reruns
+	reports []cucumber.Report
+	// quarantine is the outcome of the quarantined scenarios, nil without quarantine list
+	quarantine *quarantineResult
+	// timeoutReason is set if the run was terminated because it hit a timeout
+	timeoutReason string
+	// logcatPths are the captured logcat files, one per device
+	logcatPths []string
+	crashes    []appCrash
+}
+
+func (t testRun) shards() ([]shard, error) {
+	if len(t.parallelDevices) == 0 {
+		return nil, nil
+	}
+
+	features, err := featureFiles(t.inputs.workDir, t.inputs.options.FeaturePaths())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list feature files, error: %s", err)
+	}
+
+	shards := newShards(t.parallelDevices, features, t.inputs.reportDir)
+	logger.Printf("%d features split into %d shards", len(features), len(shards))
+	logger.Println()
+
+	return shards, nil
+}
+
+func (t testRun) singleRun() calabashRun {
+	return calabashRun{
+		calabash: t.calabash,
+		apkPth:   t.apkPth,
+		// calabash-android targets the device set in ADB_DEVICE_ARG in its adb calls
+		envs:          []string{"ADB_DEVICE_ARG=" + t.device.Serial},
+		options:       t.inputs.options,
+		jsonReportPth: t.inputs.jsonReportPth,
+		screenshotDir: screenshotDir(t.inputs.reportDir),
+		retryCount:    t.inputs.retryCount,
+		stdout:        logger.NewOutputWriter("calabash-android", os.Stdout, nil),
+		stderr:        logger.NewOutputWriter("calabash-android", os.Stderr, logger.Fields{"stream": "stderr"}),
+	}
+}
+
+// addToPlan adds the first attempt of the runs to the plan.
+func (t testRun) addToPlan(plan *executionPlan) error {
+	shards, err := t.shards()
+	if err != nil {
+		return err
+	}
+
+	runs := []calabashRun{t.singleRun()}
+	if len(shards) > 0 {
+		runs = nil
+		for _, s := range shards {
+			runs = append(runs, shardRun(t.calabash, t.apkPth, s, t.inputs.options, t.inputs.retryCount))
+		}
+	}
+
+	if t.inputs.captureLogcat {
+		if adbTool, err := adb.New(t.androidHome); err != nil {
+			plan.addNote("logcat would not be captured: %s", err)
+		} else {
+			for _, target := range t.logcatTargets(shards) {
+				clearCmd, captureCmd := logcatCommands(adbTool, target.serial)
+				plan.addCommand(planStageRun, clearCmd)
+				plan.addCommand(planStageRun, captureCmd)
+			}
+		}
+	}
+
+	for _, r := range runs {
+		runCmd, err := r.attemptCommand(0, r.options, r.features)
+		if err != nil {
+			return fmt.Errorf("failed to create command, error: %s", err)
+		}
+		plan.addCommand(planStageRun, runCmd)
+	}
+	if t.inputs.retryCount > 0 {
+		plan.addNote("failed scenarios would be rerun up to %d times", t.inputs.retryCount)
+	}
+	if t.inputs.runTimeout > 0 {
+		plan.addNote("the run would be terminated after %s", t.inputs.runTimeout)
+	}
+	if t.inputs.inactivityTimeout > 0 {
+		plan.addNote("the run would be terminated after %s without output", t.inputs.inactivityTimeout)
+	}
+	if t.inputs.quarantine != nil {
+		plan.addNote("the failures of the quarantined scenarios would not fail the run")
+	}
+	return nil
+}
+
+// execute runs the tests, the json report is written even if the tests fail.
+func (t testRun) execute() (testRunResult, error) {
+	result := testRunResult{reports: []cucumber.Report{}}
+
+	shards, err := t.shards()
+	if err != nil {
+		return result, err
+	}
+
+	// the timeouts apply to the whole run, including the reruns and every shard
+	timeouts := newRunTimeouts(t.inputs.runTimeout, t.inputs.inactivityTimeout)
+
+	run := func() error {
+		return t.runTests(shards, timeouts, &result)
+	}
+
+	var runErr error
+	if t.inputs.captureLogcat {
+		runErr = t.withLogcat(shards, &result, run)
+	} else {
+		runErr = run()
+	}
+
+	result.reports = t.reportFiles(shards)
+	exportFlakyScenarios(result.flaky)
+
+	if timeoutErr, ok := runErr.(timeoutError); ok {
+		result.timeoutReason = timeoutErr.reason
+	}
+
+	if t.inputs.quarantine != nil {
+		if features, err := cucumber.ParseReportFile(t.inputs.jsonReportPth); err != nil {
+			logger.Warnf("Failed to parse cucumber json report (%s), the quarantine list is not applied, error: %s", t.inputs.jsonReportPth, err)
+		} else {
+			quarantined := applyQuarantine(*t.inputs.quarantine, features, isStrict(t.inputs.options))
+			result.quarantine = &quarantined
+
+			// a timed out run may have skipped any scenario, an unexplained failure may have failed any scenario
+			if runErr != nil && result.timeoutReason == "" && quarantined.onlyQuarantinedFailed() {
+				logger.Println()
+				if unexplainedErr, ok := runErr.(unexplainedFailureError); ok {
+					logger.Warnf("Only quarantined scenarios failed in the report, but the run failed for another reason as well (%s)", unexplainedErr.reason)
+				} else {
+					logger.Warnf("The run failed only because of %d quarantined scenarios", len(quarantined.failed))
+					runErr = nil
+				}
+			}
+		}
+	}
+
+	if runErr != nil {
+		return result, fmt.Errorf("failed to run command, error: %s", runErr)
+	}
+	return result, nil
+}
+
+// runTests runs the shards, or the tests on the single device.
+func (t testRun) runTests(shards []shard, timeouts runTimeouts, result *testRunResult) error {
+	var runErr error
+	if len(shards) > 0 {
+		result.flaky, runErr = runShards(t.calabash, t.apkPth, shards, t.inputs.options, t.inputs.retryCount, timeouts)
+
+		shardReportPths := []string{}
+		for _, s := range shards {
+			shardReportPths = append(shardReportPths, s.jsonReportPth)
+		}
+
+		if err := mergeReports(shardReportPths, t.inputs.jsonReportPth); err != nil {
+			logger.Warnf("Failed to merge shard reports, error: %s", err)
+			if runErr != nil && !isTimeout(runErr) && !isUnexplainedFailure(runErr) {
+				// the merged report may miss the failure of the run
+				runErr = unexplainedFailureError{reason: "the shard reports could not be merged", err: runErr}
+			}
+		}
+	} else {
+		run := t.singleRun()
+		run.timeouts = timeouts
+		result.flaky, runErr = run.execute()
+	}
+	return runErr
+}
+
+// reportFiles returns the reports of the formatters written to files, with the paths resolved against the work dir.
+// Every shard writes its own reports, the reports of the reruns are returned if they were written.
+func (t testRun) reportFiles(shards []shard) []cucumber.Report {
+	suffixes := []string{""}
+	if len(shards) > 0 {
+		suffixes = nil
+		for _, s := range shards {
+			suffixes = append(suffixes, "shard"+strconv.Itoa(s.index))
+		}
+	}
+
+	reports := []cucumber.Report{}
+	for _, report := range t.inputs.options.Reports() {
+		if report.Path == "" {
+			continue
+		}
+
+		pth := report.Path
+		if !filepath.IsAbs(pth) {
+			// cucumber runs in the work dir
+			pth = filepath.Join(t.inputs.workDir, pth)
+		}
+
+		for _, suffix := range suffixes {
+			runPth := pth
+			if suffix != "" {
+				runPth = outputPathWithSuffix(pth, suffix)
+			}
+			reports = append(reports, cucumber.Report{Format: report.Format, Path: runPth})
+
+			for attempt := 1; attempt <= t.inputs.retryCount; attempt++ {
+				rerunPth := outputPathWithSuffix(runPth, "retry"+strconv.Itoa(attempt))
+				if exist, err := pathutil.IsPathExists(rerunPth); err != nil || !exist {
+					break
+				}
+				reports = append(reports, cucumber.Report{Format: report.Format, Path: rerunPth})
+			}
+		}
+	}
+	return reports
+}
+
+// logcatTargets returns the devices of the run with their logcat files.
+func (t testRun) logcatTargets(shards []shard) []logcatTarget {
+	if len(shards) == 0 {
+		return []logcatTarget{{
+			serial:    t.device.Serial,
+			pth:       filepath.Join(t.inputs.reportDir, "logcat.txt"),
+			reportPth: t.inputs.jsonReportPth,
+		}}
+	}
+
+	targets := []logcatTarget{}
+	for _, s := range shards {
+		targets = append(targets, logcatTarget{
+			serial:    s.device.Serial,
+			pth:       filepath.Join(t.inputs.reportDir, fmt.Sprintf("logcat_shard%d.txt", s.index)),
+			reportPth: s.jsonReportPth,
+		})
+	}
+	return targets
+}
+
+// withLogcat runs the tests while capturing the logcat of the devices,
+// then scans the captured logcat for the crashes of the app.
+func (t testRun) withLogcat(shards []shard, result *testRunResult, run func() error) error {
+	adbTool, err := adb.New(t.androidHome)
+	if err != nil {
+		logger.Warnf("Failed to capture logcat, error: %s", err)
+		return run()
+	}
+
+	captures, runErr := captureLogcat(adbTool, t.logcatTargets(shards), run)
+
+	if t.packageName == "" {
+		logger.Warnf("The package name of the apk is unknown, skipping crash detection")
+		if t.inputs.logcatAppOnly {
+			logger.Warnf("Exporting the whole logcat")
+		}
+	}
+
+	for _, capture := range captures {
+		pth := capture.pth
+		if t.inputs.logcatAppOnly && t.packageName != "" {
+			if filteredPth, err := filterAppLogcat(capture.pth, t.packageName); err != nil {
+				logger.Warnf("Failed to filter logcat of %s, error: %s", capture.serial, err)
+			} else {
+				pth = filteredPth
+			}
+		}
+		result.logcatPths = append(result.logcatPths, pth)
+
+		if t.packageName == "" {
+			continue
+		}
+		crashes, err := detectCrashes(capture.logcatTarget, t.packageName)
+		if err != nil {
+			logger.Warnf("Failed to detect crashes in logcat of %s, error: %s", capture.serial, err)
+			continue
+		}
+		result.crashes = append(result.crashes, crashes...)
+	}
+
+	return runErr
+}
+
+// exportReports prints and exports the paths of the reports.
+func exportReports(reports []cucumber.Report) {
+	if len(reports) == 0 {
+		return
+	}
+
+	pths := []string{}
+	logger.Println()
+	logger.Printf("Reports:")
+	for _, report := range reports {
+		logger.Printf("- %s: %s", report.Format, report.Path)
+		pths = append(pths, report.Path)
+	}
+
+	exportOutputs([][]string{{"BITRISE_CALABASH_ANDROID_REPORT_PATHS", strings.Join(pths, "|")}})
+}
+
+// reportResults exports the test results, and prints the failures of the reports if the tests failed.
+// The crashes of the app found in the logcat fail the stage, even if the tests passed.
+func reportResults(inputs stepInputs, result testRunResult, testsFailed bool) error {
+	processTestResults(inputs.jsonReportPth, inputs.junitReportPth)
+	exportScreenshots(inputs.jsonReportPth, screenshotDir(inputs.reportDir))
+
+	if len(result.logcatPths) > 0 {
+		logger.Printf("logcat captured to: %s", strings.Join(result.logcatPths, ", "))
+		exportOutputs([][]string{{"BITRISE_CALABASH_ANDROID_LOGCAT_PATH", strings.Join(result.logcatPths, "|")}})
+	}
+
+	exportReports(result.reports)
+
+	if result.quarantine != nil {
+		reportQuarantine(*result.quarantine)
+	}
+
+	if testsFailed {
+		failures := []cucumber.HTMLFailure{}
+		for _, report := range result.reports {
+			logger.Println()
+			reportFailures, err := readReportFailures(report)
+			if err != nil {
+				return fmt.Errorf("failed to read output file (%s), error: %s", report.Path, err)
+			}
+			failures = append(failures, reportFailures...)
+		}
+		printFailureSummary(failures, inputs.maxPrintedFailures)
+	}
+
+	if inputs.captureLogcat {
+		// printed after the failures of the reports, as the cause of the failures
+		return reportCrashes(result.crashes, inputs.failOnCrash)
+	}
+	return nil
+}
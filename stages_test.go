@@ -0,0 +1,741 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/adb"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/cucumber"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/quarantine"
+)
+
+const testGemfileLock = `GEM
+  remote: https://rubygems.org/
+  specs:
+    calabash-android (0.9.8)
+      cucumber (~> 2.0)
+    cucumber (2.99.0)
+
+PLATFORMS
+  ruby
+
+DEPENDENCIES
+  calabash-android
+
+BUNDLED WITH
+   1.17.3
+`
+
+func writeTestFile(t *testing.T, pth, content string) {
+	t.Helper()
+	if err := pathutil.EnsureDirExist(filepath.Dir(pth)); err != nil {
+		t.Fatalf("failed to create dir for %s: %s", pth, err)
+	}
+	if err := fileutil.WriteStringToFile(pth, content); err != nil {
+		t.Fatalf("failed to write %s: %s", pth, err)
+	}
+}
+
+func TestCalabashAndroidGemDecision(t *testing.T) {
+	const (
+		gemList       = "gem list ^calabash-android$"
+		remoteGemList = "gem list ^calabash-android$ --remote --all"
+	)
+
+	tests := []struct {
+		name        string
+		version     string
+		gemfile     bool
+		gemfileLock bool
+		systemRuby  bool
+		installed   string
+		remote      string
+
+		wantErr      string
+		wantCommands []string
+		wantVersion  string
+		wantResign   string
+		wantEnvs     []string
+	}{
+		{
+			name:         "exact version installed",
+			version:      "0.9.8",
+			installed:    "calabash-android (0.9.8, 0.9.0)",
+			wantCommands: []string{gemList},
+			wantVersion:  "0.9.8",
+			wantResign:   "calabash-android _0.9.8_ resign app.apk",
+		},
+		{
+			name:         "exact version not installed",
+			version:      "0.9.8",
+			installed:    "calabash-android (0.9.80, 0.9.0)",
+			wantCommands: []string{gemList, "gem install calabash-android --no-document -v 0.9.8"},
+			wantVersion:  "0.9.8",
+			wantResign:   "calabash-android _0.9.8_ resign app.apk",
+		},
+		{
+			name:         "exact version not installed with the system ruby",
+			version:      "0.9.8",
+			systemRuby:   true,
+			installed:    "",
+			wantCommands: []string{gemList, "sudo gem install calabash-android --no-document -v 0.9.8"},
+			wantVersion:  "0.9.8",
+			wantResign:   "calabash-android _0.9.8_ resign app.apk",
+		},
+		{
+			name:         "constraint matching an installed version",
+			version:      "~> 0.9.0",
+			installed:    "calabash-android (0.10.0, 0.9.8, 0.9.0)",
+			wantCommands: []string{gemList, gemList},
+			wantVersion:  "0.9.8",
+			wantResign:   "calabash-android _0.9.8_ resign app.apk",
+		},
+		{
+			name:         "constraint matching a remote version",
+			version:      ">= 0.9.0, < 0.11",
+			installed:    "calabash-android (0.8.0)",
+			remote:       "calabash-android (0.11.0, 0.10.1, 0.10.0, 0.9.8)",
+			wantCommands: []string{gemList, remoteGemList, gemList, "gem install calabash-android --no-document -v 0.10.1"},
+			wantVersion:  "0.10.1",
+			wantResign:   "calabash-android _0.10.1_ resign app.apk",
+		},
+		{
+			name:         "constraint matching no version",
+			version:      "~> 5.0",
+			installed:    "calabash-android (0.9.8)",
+			remote:       "calabash-android (0.9.8)",
+			wantErr:      "no calabash-android version matches: ~> 5.0",
+			wantCommands: []string{gemList, remoteGemList},
+		},
+		{
+			name:         "Gemfile.lock",
+			gemfile:      true,
+			gemfileLock:  true,
+			wantCommands: []string{"bundle install --jobs 20 --retry 5"},
+			wantVersion:  "0.9.8",
+			wantResign:   "bundle exec calabash-android resign app.apk",
+			wantEnvs:     []string{"BUNDLE_GEMFILE="},
+		},
+		{
+			name:         "version input overrides Gemfile.lock",
+			version:      "0.9.0",
+			gemfile:      true,
+			gemfileLock:  true,
+			installed:    "calabash-android (0.9.0)",
+			wantCommands: []string{gemList},
+			wantVersion:  "0.9.0",
+			wantResign:   "calabash-android _0.9.0_ resign app.apk",
+		},
+		{
+			name:         "Gemfile without Gemfile.lock",
+			gemfile:      true,
+			installed:    "calabash-android (0.9.8, 0.9.0)",
+			wantCommands: []string{"gem install calabash-android --no-document", gemList},
+			wantVersion:  "0.9.8",
+			wantResign:   "calabash-android resign app.apk",
+		},
+		{
+			name:         "latest version",
+			installed:    "calabash-android (0.9.8, 0.9.0)",
+			wantCommands: []string{"gem install calabash-android --no-document", gemList},
+			wantVersion:  "0.9.8",
+			wantResign:   "calabash-android resign app.apk",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := withFakeExecutor(t)
+			fake.systemRuby = tt.systemRuby
+			fake.respond(gemList, tt.installed, nil)
+			fake.respond(remoteGemList, tt.remote, nil)
+
+			workDir := t.TempDir()
+			gemFilePath := ""
+			if tt.gemfile {
+				gemFilePath = filepath.Join(workDir, "Gemfile")
+				writeTestFile(t, gemFilePath, "source 'https://rubygems.org'\ngem 'calabash-android'\n")
+			}
+			if tt.gemfileLock {
+				writeTestFile(t, filepath.Join(workDir, "Gemfile.lock"), testGemfileLock)
+			}
+
+			setup, err := resolveCalabashAndroid(tt.version, gemFilePath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want: %s", err, tt.wantErr)
+				}
+				requireCommands(t, fake, tt.wantCommands...)
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveCalabashAndroid() error: %s", err)
+			}
+
+			version, err := installCalabashAndroid(setup, nil)
+			if err != nil {
+				t.Fatalf("installCalabashAndroid() error: %s", err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("version = %s, want: %s", version, tt.wantVersion)
+			}
+			requireCommands(t, fake, tt.wantCommands...)
+
+			fake.invocations = nil
+			if err := resignAPK(setup.calabash(workDir), "app.apk", nil); err != nil {
+				t.Fatalf("resignAPK() error: %s", err)
+			}
+			requireCommands(t, fake, tt.wantResign)
+
+			resign := fake.invocations[0]
+			if resign.dir != workDir {
+				t.Errorf("resign dir = %s, want: %s", resign.dir, workDir)
+			}
+			for _, prefix := range tt.wantEnvs {
+				if !strings.HasPrefix(strings.Join(resign.envs, "\n"), prefix) {
+					t.Errorf("resign envs = %v, want: %s", resign.envs, prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestResignFailure(t *testing.T) {
+	fake := withFakeExecutor(t)
+	fake.respond("calabash-android", "", errors.New("exit status 1"))
+
+	err := resignAPK(calabashAndroid{version: "0.9.8", workDir: t.TempDir()}, "app.apk", nil)
+	if err == nil || !strings.Contains(err.Error(), "exit status 1") {
+		t.Fatalf("error = %v, want the resign error", err)
+	}
+}
+
+func TestSelectDevices(t *testing.T) {
+	androidHome := t.TempDir()
+	writeTestFile(t, filepath.Join(androidHome, "platform-tools", "adb"), "")
+
+	devices := `List of devices attached
+emulator-5556          device product:sdk_gphone_x86 model:Pixel_3 device:generic_x86 transport_id:2
+emulator-5554          offline transport_id:1`
+
+	fake := withFakeExecutor(t)
+	fake.respond(filepath.Join(androidHome, "platform-tools", "adb")+" devices -l", devices, nil)
+
+	device, parallel, err := selectDevices(androidHome, "", true, 0, nil)
+	if err != nil {
+		t.Fatalf("selectDevices() error: %s", err)
+	}
+	if device.Serial != "emulator-5556" || parallel != nil {
+		t.Errorf("device = %s, parallel = %v, want emulator-5556 without parallel devices", device, parallel)
+	}
+
+	if _, _, err := selectDevices(androidHome, "emulator-5554", false, 0, nil); err == nil {
+		t.Errorf("selectDevices() selected an offline device")
+	}
+}
+
+func TestWaitForBoot(t *testing.T) {
+	androidHome := t.TempDir()
+	adbPth := filepath.Join(androidHome, "platform-tools", "adb")
+	writeTestFile(t, adbPth, "")
+
+	interval := devicePollInterval
+	devicePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { devicePollInterval = interval })
+
+	fake := withFakeExecutor(t)
+	fake.respond(adbPth+" -s emulator-5554 shell getprop sys.boot_completed", "1", nil)
+	fake.respond(adbPth+" -s emulator-5554 shell getprop init.svc.bootanim", "stopped", nil)
+	fake.respond(adbPth+" -s emulator-5556 shell getprop sys.boot_completed", "", nil)
+	fake.respond(adbPth+" -s emulator-5556 shell getprop init.svc.bootanim", "running", nil)
+
+	if err := waitForBoot(androidHome, []adb.Device{{Serial: "emulator-5554"}}, time.Second); err != nil {
+		t.Fatalf("waitForBoot() error: %s", err)
+	}
+	requireCommands(t, fake,
+		adbPth+" -s emulator-5554 shell getprop sys.boot_completed",
+		adbPth+" -s emulator-5554 shell getprop init.svc.bootanim",
+	)
+
+	err := waitForBoot(androidHome, []adb.Device{{Serial: "emulator-5556"}}, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), `init.svc.bootanim: "running"`) {
+		t.Errorf("waitForBoot() error = %v, want the boot timeout", err)
+	}
+
+	// the device list is polled until the timeout
+	fake.invocations = nil
+	fake.respond(adbPth+" devices -l", "List of devices attached\nemulator-5554          offline transport_id:1", nil)
+	if _, _, err := selectDevices(androidHome, "", false, 50*time.Millisecond, nil); err == nil {
+		t.Errorf("selectDevices() succeeded without an online device")
+	}
+	if polls := len(fake.commands()); polls < 2 {
+		t.Errorf("devices listed %d times, want polling", polls)
+	}
+}
+
+const testCucumberReport = `[{"uri":"features/login.feature","name":"Login","elements":[
+{"type":"scenario","name":"Valid login","line":3,"steps":[{"keyword":"Given ","name":"I log in","result":{"status":"passed","duration":1000000000}}]},
+{"type":"scenario","name":"Invalid login","line":8,"steps":[{"keyword":"Then ","name":"I see an error","result":{"status":"failed","duration":2000000000,"error_message":"element not found"}}]}
+]}]`
+
+func TestFailedTestRunReport(t *testing.T) {
+	fake := withFakeExecutor(t)
+	fake.respond("calabash-android _0.9.8_ run", "", errors.New("exit status 1"))
+
+	reportDir := t.TempDir()
+	inputs := stepInputs{
+		workDir:        t.TempDir(),
+		options:        cucumber.Options{{Name: "--format", Value: "html"}, {Name: "--out", Value: filepath.Join(reportDir, "report.html")}},
+		reportDir:      reportDir,
+		jsonReportPth:  filepath.Join(reportDir, "calabash-android_report.json"),
+		junitReportPth: filepath.Join(reportDir, "junit", "TEST-calabash-android.xml"),
+	}
+
+	run := testRun{
+		calabash: calabashAndroid{version: "0.9.8", workDir: inputs.workDir},
+		apkPth:   "app.apk",
+		device:   adb.Device{Serial: "emulator-5554"},
+		inputs:   inputs,
+	}
+
+	result, err := run.execute()
+	if err == nil {
+		t.Fatalf("execute() succeeded, want the test failure")
+	}
+
+	requireCommands(t, fake, "calabash-android _0.9.8_ run app.apk --format html --out "+filepath.Join(reportDir, "report.html")+" --format json --out "+inputs.jsonReportPth)
+	wantEnvs := []string{"ADB_DEVICE_ARG=emulator-5554", "SCREENSHOT_PATH=" + filepath.Join(reportDir, "screenshots") + "/"}
+	if envs := fake.invocations[0].envs; strings.Join(envs, " ") != strings.Join(wantEnvs, " ") {
+		t.Errorf("run envs = %v, want: %v", envs, wantEnvs)
+	}
+
+	// the run writes the reports and the screenshots
+	writeTestFile(t, inputs.jsonReportPth, testCucumberReport)
+	writeTestFile(t, filepath.Join(reportDir, "report.html"), testHTMLReport)
+	writeTestFile(t, filepath.Join(reportDir, "screenshots", "screenshot_0.png"), "png")
+
+	deployDir := t.TempDir()
+	t.Setenv("BITRISE_DEPLOY_DIR", deployDir)
+
+	if err := reportResults(inputs, result, true); err != nil {
+		t.Fatalf("reportResults() error: %s", err)
+	}
+
+	exports := fake.exports()
+	for key, want := range map[string]string{
+		"BITRISE_CALABASH_ANDROID_PASSED_COUNT":  "1",
+		"BITRISE_CALABASH_ANDROID_FAILED_COUNT":  "1",
+		"BITRISE_CALABASH_ANDROID_FLAKY_COUNT":   "0",
+		"BITRISE_CALABASH_ANDROID_TEST_DURATION": "3.000",
+
+		"BITRISE_CALABASH_ANDROID_SCREENSHOTS_ZIP_PATH": filepath.Join(deployDir, "calabash-android_screenshots.zip"),
+		"BITRISE_CALABASH_ANDROID_REPORT_PATHS":         filepath.Join(reportDir, "report.html"),
+		"BITRISE_CALABASH_ANDROID_TOTAL_COUNT":          "2",
+		"BITRISE_CALABASH_ANDROID_JSON_REPORT_PATH":     inputs.jsonReportPth,
+		"BITRISE_CALABASH_ANDROID_JUNIT_REPORT_PATH":    inputs.junitReportPth,
+		"BITRISE_CALABASH_ANDROID_SCREENSHOTS_DIR":      filepath.Join(reportDir, "screenshots"),
+	} {
+		if exports[key] != want {
+			t.Errorf("%s = %q, want: %q", key, exports[key], want)
+		}
+	}
+
+	junitReport, err := fileutil.ReadStringFromFile(inputs.junitReportPth)
+	if err != nil {
+		t.Fatalf("junit report not written: %s", err)
+	}
+	if !strings.Contains(junitReport, "element not found") {
+		t.Errorf("junit report has no failure message:\n%s", junitReport)
+	}
+
+	// the same outputs are exported if the tests passed
+	fake.invocations = nil
+	if err := reportResults(inputs, result, false); err != nil {
+		t.Fatalf("reportResults() error: %s", err)
+	}
+	for _, key := range []string{"BITRISE_CALABASH_ANDROID_REPORT_PATHS", "BITRISE_CALABASH_ANDROID_TOTAL_COUNT", "BITRISE_CALABASH_ANDROID_JSON_REPORT_PATH", "BITRISE_CALABASH_ANDROID_SCREENSHOTS_ZIP_PATH"} {
+		if _, ok := fake.exports()[key]; !ok {
+			t.Errorf("%s is not exported if the tests passed", key)
+		}
+	}
+
+	// a missing report of a failed run is a failure of the report stage
+	result.reports = []cucumber.Report{{Format: "html", Path: filepath.Join(reportDir, "missing.html")}}
+	if err := reportResults(inputs, result, true); err == nil {
+		t.Errorf("reportResults() succeeded with a missing report")
+	}
+}
+
+func TestReportFiles(t *testing.T) {
+	workDir := t.TempDir()
+
+	options := cucumber.Options{
+		{Name: "--format", Value: "html"}, {Name: "--out", Value: "reports/report.html"},
+		{Name: "--format", Value: "junit"}, {Name: "--out", Value: "/tmp/junit"},
+		{Name: "--format", Value: "pretty"},
+		{Value: "features/login.feature"},
+	}
+
+	// the rerun of the first shard wrote its html report
+	writeTestFile(t, filepath.Join(workDir, "reports", "report_shard0_retry1.html"), "")
+
+	run := testRun{inputs: stepInputs{workDir: workDir, options: options, retryCount: 2}}
+	reports := run.reportFiles([]shard{{index: 0}, {index: 1}})
+
+	got := []string{}
+	for _, report := range reports {
+		got = append(got, report.Format+": "+report.Path)
+	}
+	want := []string{
+		"html: " + filepath.Join(workDir, "reports", "report_shard0.html"),
+		"html: " + filepath.Join(workDir, "reports", "report_shard0_retry1.html"),
+		"html: " + filepath.Join(workDir, "reports", "report_shard1.html"),
+		"junit: /tmp/junit_shard0",
+		"junit: /tmp/junit_shard1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("reports:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// testHTMLReport is the html report of testCucumberReport.
+const testHTMLReport = `<html><body><div class="cucumber">
+<div class="feature"><h2><span class="val">Feature: Login</span></h2>
+<div class='scenario'><span class="scenario_file">features/login.feature:8</span><h3 id="scenario_2"><span class="keyword">Scenario:</span> <span class="val">Invalid login</span></h3><ol>
+<li id='' class='step failed'><div class="step_name"><span class="keyword">Then </span><span class="step val">I see an error</span></div><div class="step_file"><span>features/step_definitions/login_steps.rb:5</span></div><div class="message"><pre>element not found (RuntimeError)</pre></div></li>
+</ol></div>
+</div></div></body></html>`
+
+func TestRunInactivityTimeout(t *testing.T) {
+	fake := withFakeExecutor(t)
+	fake.responses["calabash-android _0.9.8_ run"] = fakeResponse{out: "Feature: login\n", hang: true}
+
+	reportDir := t.TempDir()
+	run := testRun{
+		calabash: calabashAndroid{version: "0.9.8", workDir: t.TempDir()},
+		apkPth:   "app.apk",
+		device:   adb.Device{Serial: "emulator-5554"},
+		inputs: stepInputs{
+			retryCount:        1,
+			inactivityTimeout: 100 * time.Millisecond,
+			reportDir:         reportDir,
+			jsonReportPth:     filepath.Join(reportDir, "calabash-android_report.json"),
+		},
+	}
+
+	result, err := run.execute()
+	if err == nil {
+		t.Fatalf("execute() succeeded, want the timeout")
+	}
+	if !strings.Contains(result.timeoutReason, "inactivity timeout") {
+		t.Errorf("timeout reason = %q, want the inactivity timeout", result.timeoutReason)
+	}
+
+	// the timed out run is interrupted and not rerun
+	if len(fake.signals) != 1 || fake.signals[0] != syscall.SIGINT {
+		t.Errorf("signals = %v, want an interrupt", fake.signals)
+	}
+	if commands := fake.commands(); len(commands) != 1 {
+		t.Errorf("commands = %v, want the first attempt only", commands)
+	}
+}
+
+func TestWatchdogKillsAfterGracePeriod(t *testing.T) {
+	fake := withFakeExecutor(t)
+	fake.responses["calabash-android run"] = fakeResponse{hang: true, ignoreInterrupt: true}
+
+	cmd, err := fake.NewRubyCommand("calabash-android", "run", "app.apk")
+	if err != nil {
+		t.Fatalf("NewRubyCommand() error: %s", err)
+	}
+	// the existing process attributes are kept
+	cmd.GetCmd().SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	w := newWatchdog(cmd, newRunTimeouts(50*time.Millisecond, 0))
+	w.gracePeriod = 50 * time.Millisecond
+
+	err = w.run()
+	if !isTimeout(err) || !strings.Contains(err.Error(), "run timeout") {
+		t.Fatalf("run() error = %v, want the run timeout", err)
+	}
+	if want := []syscall.Signal{syscall.SIGINT, syscall.SIGKILL}; !reflect.DeepEqual(fake.signals, want) {
+		t.Errorf("signals = %v, want: %v", fake.signals, want)
+	}
+	if attr := cmd.GetCmd().SysProcAttr; !attr.Setsid || !attr.Setpgid {
+		t.Errorf("SysProcAttr = %+v, want Setsid kept and Setpgid set", attr)
+	}
+}
+
+func TestRunCapturesAppLogcat(t *testing.T) {
+	androidHome := t.TempDir()
+	adbPth := filepath.Join(androidHome, "platform-tools", "adb")
+	writeTestFile(t, adbPth, "")
+
+	fake := withFakeExecutor(t)
+	fake.respond(adbPth+" -s emulator-5554 logcat -v threadtime", `--------- beginning of main
+10-17 04:15:28.100   500   520 I ActivityManager: Start proc 1234:com.example.app/u0a76 for activity {com.example.app/com.example.app.MainActivity}
+10-17 04:15:28.200  1234  1234 D MainActivity: onCreate
+10-17 04:15:28.300   900   900 I wifi: scan
+10-17 04:15:29.000  1234  1250 E AndroidRuntime: FATAL EXCEPTION: main
+`, nil)
+
+	reportDir := t.TempDir()
+	run := testRun{
+		calabash:    calabashAndroid{version: "0.9.8", workDir: t.TempDir()},
+		apkPth:      "app.apk",
+		device:      adb.Device{Serial: "emulator-5554"},
+		androidHome: androidHome,
+		packageName: "com.example.app",
+		inputs: stepInputs{
+			captureLogcat: true,
+			logcatAppOnly: true,
+			reportDir:     reportDir,
+			jsonReportPth: filepath.Join(reportDir, "calabash-android_report.json"),
+		},
+	}
+
+	result, err := run.execute()
+	if err != nil {
+		t.Fatalf("execute() error: %s", err)
+	}
+
+	commands := fake.commands()
+	if len(commands) != 3 || commands[0] != adbPth+" -s emulator-5554 logcat -c" || !strings.Contains(commands[2], " run app.apk") {
+		t.Fatalf("commands = %v, want the logcat cleared and captured before the run", commands)
+	}
+	if len(fake.signals) != 1 || fake.signals[0] != syscall.SIGTERM {
+		t.Errorf("signals = %v, want logcat terminated", fake.signals)
+	}
+
+	if len(result.logcatPths) != 1 {
+		t.Fatalf("logcat paths = %v, want the app logcat", result.logcatPths)
+	}
+	appLogcat, err := fileutil.ReadStringFromFile(result.logcatPths[0])
+	if err != nil {
+		t.Fatalf("failed to read app logcat: %s", err)
+	}
+	if strings.Contains(appLogcat, "wifi") || strings.Count(appLogcat, "\n") != 3 {
+		t.Errorf("app logcat:\n%s\nwant the lines of the app only", appLogcat)
+	}
+}
+
+func TestPipelineFailure(t *testing.T) {
+	ran := []string{}
+	stageFunc := func(name string, err error) func() error {
+		return func() error {
+			ran = append(ran, name)
+			return err
+		}
+	}
+
+	p := pipeline{}
+	p.add("first", "First...", stageFunc("first", nil))
+	p.add("second", "Second...", stageFunc("second", errors.New("second failed")))
+	p.add("third", "Third...", stageFunc("third", nil))
+	p.addAlways("report", "Report...", stageFunc("report", nil))
+	p.addAlways("cleanup", "Cleanup...", stageFunc("cleanup", errStageSkipped))
+
+	err := p.run()
+	if err == nil || err.Error() != "second stage failed, error: second failed" {
+		t.Fatalf("error = %v, want the second stage's error", err)
+	}
+
+	if strings.Join(ran, ",") != "first,second,report,cleanup" {
+		t.Errorf("ran = %v, want the stages up to the failure and the always stages", ran)
+	}
+
+	statuses := []string{}
+	for _, result := range p.results {
+		statuses = append(statuses, result.name+":"+result.status)
+	}
+	want := "first:succeeded,second:failed,third:skipped,report:succeeded,cleanup:skipped"
+	if strings.Join(statuses, ",") != want {
+		t.Errorf("statuses = %s, want: %s", strings.Join(statuses, ","), want)
+	}
+}
+
+func TestExportTestResult(t *testing.T) {
+	fake := withFakeExecutor(t)
+
+	keys := testResultKeys
+	t.Cleanup(func() { testResultKeys = keys })
+
+	for _, tt := range []struct {
+		outputs string
+		want    []string
+	}{
+		{outputs: "", want: []string{"BITRISE_CALABASH_ANDROID_TEST_RESULT", "BITRISE_XAMARIN_TEST_RESULT"}},
+		{outputs: "calabash", want: []string{"BITRISE_CALABASH_ANDROID_TEST_RESULT"}},
+		{outputs: "xamarin", want: []string{"BITRISE_XAMARIN_TEST_RESULT"}},
+	} {
+		var err error
+		if testResultKeys, err = testResultOutputKeys(tt.outputs); err != nil {
+			t.Fatalf("testResultOutputKeys(%q) error: %s", tt.outputs, err)
+		}
+
+		fake.invocations = nil
+		exportTestResult(testResultTimedOut)
+
+		exports := fake.exports()
+		if len(exports) != len(tt.want) {
+			t.Errorf("outputs %q: exported %v, want: %v", tt.outputs, exports, tt.want)
+		}
+		for _, key := range tt.want {
+			if exports[key] != testResultTimedOut {
+				t.Errorf("outputs %q: %s = %q, want: %q", tt.outputs, key, exports[key], testResultTimedOut)
+			}
+		}
+	}
+
+	if _, err := testResultOutputKeys("all"); err == nil {
+		t.Errorf("testResultOutputKeys() succeeded with an invalid input")
+	}
+}
+
+func TestRunWithQuarantine(t *testing.T) {
+	const report = `[{"uri":"features/login.feature","name":"Login","tags":[{"name":"@login"}],"elements":[
+{"type":"scenario","name":"Valid login","line":3,"tags":[{"name":"@flaky"}],"steps":[{"keyword":"Given ","name":"I log in","result":{"status":"passed","duration":1000000000}}]},
+{"type":"scenario","name":"Invalid login","line":8,"steps":[{"keyword":"Then ","name":"I see an error","result":{"status":"failed","duration":2000000000,"error_message":"element not found"}}]}
+]}]`
+
+	tests := []struct {
+		name       string
+		list       quarantine.List
+		wantErr    bool
+		wantFailed string
+		wantPassed string
+	}{
+		{
+			name:       "failed scenario quarantined by name",
+			list:       quarantine.List{Scenarios: []string{"Invalid login"}, Tags: []string{"@flaky"}},
+			wantFailed: "Invalid login (features/login.feature:8), quarantined by scenario Invalid login",
+			wantPassed: "Valid login (features/login.feature:3), quarantined by tag @flaky",
+		},
+		{
+			name:       "failed scenario quarantined by location",
+			list:       quarantine.List{Features: []string{"features/login.feature:8"}},
+			wantFailed: "Invalid login (features/login.feature:8), quarantined by feature features/login.feature:8",
+		},
+		{
+			name:       "failed scenario not quarantined",
+			list:       quarantine.List{Tags: []string{"@flaky"}},
+			wantErr:    true,
+			wantPassed: "Valid login (features/login.feature:3), quarantined by tag @flaky",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := withFakeExecutor(t)
+			fake.respond("calabash-android _0.9.8_ run", "", errors.New("exit status 1"))
+
+			reportDir := t.TempDir()
+			run := testRun{
+				calabash: calabashAndroid{version: "0.9.8", workDir: t.TempDir()},
+				apkPth:   "app.apk",
+				device:   adb.Device{Serial: "emulator-5554"},
+				inputs: stepInputs{
+					reportDir:     reportDir,
+					jsonReportPth: filepath.Join(reportDir, "calabash-android_report.json"),
+					quarantine:    &tt.list,
+				},
+			}
+			writeTestFile(t, run.inputs.jsonReportPth, report)
+
+			result, err := run.execute()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("execute() error = %v, want error: %v", err, tt.wantErr)
+			}
+
+			reportQuarantine(*result.quarantine)
+			exports := fake.exports()
+			if got := exports["BITRISE_CALABASH_ANDROID_QUARANTINED_FAILED_SCENARIOS"]; got != tt.wantFailed {
+				t.Errorf("quarantined failed scenarios = %q, want: %q", got, tt.wantFailed)
+			}
+			if got := exports["BITRISE_CALABASH_ANDROID_QUARANTINED_PASSED_SCENARIOS"]; got != tt.wantPassed {
+				t.Errorf("quarantined passed scenarios = %q, want: %q", got, tt.wantPassed)
+			}
+		})
+	}
+}
+
+func TestStagesSkipWithoutTheirInputs(t *testing.T) {
+	fake := withFakeExecutor(t)
+
+	// no boot timeout
+	if err := waitForBootStage(ConfigsModel{}, validateOutput{}, deviceSelection{device: adb.Device{Serial: "emulator-5554"}}); err != errStageSkipped {
+		t.Errorf("waitForBootStage() = %v, want skipped without boot timeout", err)
+	}
+
+	// the tests did not run
+	if err := reportStage(ConfigsModel{}, validateOutput{}, testsOutput{}); err != errStageSkipped {
+		t.Errorf("reportStage() = %v, want skipped if the tests did not run", err)
+	}
+
+	// dry run
+	plan := &executionPlan{}
+	validated := validateOutput{inputs: stepInputs{bootTimeout: time.Minute}, plan: plan}
+	if err := waitForBootStage(ConfigsModel{}, validated, deviceSelection{device: adb.Device{Serial: "emulator-5554"}}); err != nil {
+		t.Errorf("waitForBootStage() error: %s", err)
+	}
+	if err := installGemStage(gemSetup{version: "0.9.8"}, plan); err != nil {
+		t.Errorf("installGemStage() error: %s", err)
+	}
+	if exports := fake.exports(); len(exports) != 0 {
+		t.Errorf("exports in dry run mode: %v", exports)
+	}
+}
+
+func TestRunWithQuarantineMissingShardReport(t *testing.T) {
+	fake := withFakeExecutor(t)
+	fake.respond("calabash-android _0.9.8_ run", "", errors.New("exit status 1"))
+
+	workDir := t.TempDir()
+	writeTestFile(t, filepath.Join(workDir, "features", "login.feature"), "")
+	writeTestFile(t, filepath.Join(workDir, "features", "search.feature"), "")
+
+	reportDir := t.TempDir()
+	list := quarantine.List{Scenarios: []string{"Invalid login"}}
+	run := testRun{
+		calabash:        calabashAndroid{version: "0.9.8", workDir: workDir},
+		apkPth:          "app.apk",
+		parallelDevices: []adb.Device{{Serial: "emulator-5554"}, {Serial: "emulator-5556"}},
+		inputs: stepInputs{
+			workDir:       workDir,
+			reportDir:     reportDir,
+			jsonReportPth: filepath.Join(reportDir, "calabash-android_report.json"),
+			quarantine:    &list,
+		},
+	}
+
+	// the only failed scenario of the first shard is quarantined, the second shard failed without a report
+	writeTestFile(t, filepath.Join(reportDir, "calabash-android_report_shard0.json"), testCucumberReport)
+
+	result, err := run.execute()
+	if err == nil {
+		t.Fatalf("execute() succeeded, want the failure of the shard without report")
+	}
+	if result.quarantine == nil || len(result.quarantine.failed) != 1 || result.quarantine.unquarantinedFailures != 0 {
+		t.Fatalf("quarantine = %+v, want the quarantined failure of the first shard", result.quarantine)
+	}
+
+	// the second shard failed without a failed scenario, like on a crash of the test server
+	writeTestFile(t, filepath.Join(reportDir, "calabash-android_report_shard1.json"), "[]")
+	if _, err := run.execute(); err == nil {
+		t.Fatalf("execute() succeeded, want the failure of the shard without failed scenario")
+	}
+
+	// the quarantined failure passes the run, if only the shard with the quarantined failure failed
+	fake.respond("calabash-android _0.9.8_ run app.apk --require features --format pretty --format json --out "+filepath.Join(reportDir, "calabash-android_report_shard1.json"), "", nil)
+
+	if _, err := run.execute(); err != nil {
+		t.Fatalf("execute() error: %s, want the quarantined failure to pass the run", err)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-steplib/steps-calabash-android-uitest/logger"
+)
+
+// defaultTerminateGracePeriod is the time between interrupting the process group of the run and killing it,
+// cucumber writes its reports when it is interrupted.
+const defaultTerminateGracePeriod = 10 * time.Second
+
+// timeoutError is the error of a run, which was terminated because it hit a timeout.
+type timeoutError struct {
+	reason string
+	// err is the error of the whole run, if only a part of it timed out, like a shard
+	err error
+}
+
+func (err timeoutError) Error() string {
+	if err.err != nil {
+		return err.err.Error()
+	}
+	return "timed out, " + err.reason
+}
+
+func isTimeout(err error) bool {
+	_, ok := err.(timeoutError)
+	return ok
+}
+
+// runTimeouts are the limits of the calabash-android run, zero values mean no limit.
+type runTimeouts struct {
+	run time.Duration
+	// deadline is the end of the whole run, including the reruns and every shard
+	deadline time.Time
+	// inactivity is the longest time the run can go without any output
+	inactivity time.Duration
+}
+
+// newRunTimeouts returns the timeouts of a run starting now.
+func newRunTimeouts(run, inactivity time.Duration) runTimeouts {
+	timeouts := runTimeouts{run: run, inactivity: inactivity}
+	if run > 0 {
+		timeouts.deadline = time.Now().Add(run)
+	}
+	return timeouts
+}
+
+func (timeouts runTimeouts) enabled() bool {
+	return !timeouts.deadline.IsZero() || timeouts.inactivity > 0
+}
+
+// exceeded returns the reason if the deadline of the run has already passed.
+func (timeouts runTimeouts) exceeded() (string, bool) {
+	if timeouts.deadline.IsZero() || time.Now().Before(timeouts.deadline) {
+		return "", false
+	}
+	return timeouts.runTimeoutReason(), true
+}
+
+func (timeouts runTimeouts) runTimeoutReason() string {
+	return fmt.Sprintf("the run did not finish within the run timeout (%s)", timeouts.run)
+}
+
+func (timeouts runTimeouts) inactivityTimeoutReason() string {
+	return fmt.Sprintf("the run printed no output within the inactivity timeout (%s)", timeouts.inactivity)
+}
+
+// activityWriter records the time of the last write.
+type activityWriter struct {
+	writer    io.Writer
+	lastWrite *int64
+}
+
+func (w activityWriter) Write(p []byte) (int, error) {
+	atomic.StoreInt64(w.lastWrite, time.Now().UnixNano())
+	return w.writer.Write(p)
+}
+
+// watchdog runs a command, watches its output and terminates its process group if it hits a timeout.
+type watchdog struct {
+	cmd      *command.Model
+	timeouts runTimeouts
+	// gracePeriod is the time between interrupting and killing the command
+	gracePeriod time.Duration
+
+	lastWrite int64
+	done      chan struct{}
+	finished  chan struct{}
+	// reason is set if the command was terminated, read it after finished is closed
+	reason string
+}
+
+// newWatchdog prepares the command to be watched, call it after the outputs of the command are set.
+func newWatchdog(cmd *command.Model, timeouts runTimeouts) *watchdog {
+	w := &watchdog{
+		cmd:         cmd,
+		timeouts:    timeouts,
+		gracePeriod: defaultTerminateGracePeriod,
+		lastWrite:   time.Now().UnixNano(),
+		done:        make(chan struct{}),
+		finished:    make(chan struct{}),
+	}
+
+	execCmd := cmd.GetCmd()
+	// calabash-android starts the test server and adb processes, the whole process group is terminated
+	if execCmd.SysProcAttr == nil {
+		execCmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	execCmd.SysProcAttr.Setpgid = true
+
+	sameWriter := execCmd.Stdout == execCmd.Stderr
+	if execCmd.Stdout != nil {
+		execCmd.Stdout = activityWriter{writer: execCmd.Stdout, lastWrite: &w.lastWrite}
+	}
+	if sameWriter {
+		// exec.Cmd serializes the writes only if the outputs are the same writer
+		execCmd.Stderr = execCmd.Stdout
+	} else if execCmd.Stderr != nil {
+		execCmd.Stderr = activityWriter{writer: execCmd.Stderr, lastWrite: &w.lastWrite}
+	}
+
+	return w
+}
+
+// run runs the command and watches it until it exits, it returns a timeoutError if the command was terminated.
+func (w *watchdog) run() error {
+	startTime := time.Now()
+	if err := cmdExecutor.Start(w.cmd); err != nil {
+		logCommandResult(w.cmd, startTime, err)
+		return err
+	}
+
+	// the command can be signaled only after it was started
+	go w.watch()
+
+	err := cmdExecutor.Wait(w.cmd)
+	logCommandResult(w.cmd, startTime, err)
+
+	close(w.done)
+	<-w.finished
+
+	if w.reason != "" {
+		return timeoutError{reason: w.reason}
+	}
+	return err
+}
+
+func (w *watchdog) watch() {
+	defer close(w.finished)
+
+	var deadline <-chan time.Time
+	if !w.timeouts.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(w.timeouts.deadline))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	var tick <-chan time.Time
+	if w.timeouts.inactivity > 0 {
+		interval := time.Second
+		if w.timeouts.inactivity/4 < interval {
+			interval = w.timeouts.inactivity / 4
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-deadline:
+			w.terminate(w.timeouts.runTimeoutReason())
+			return
+		case <-tick:
+			lastWrite := time.Unix(0, atomic.LoadInt64(&w.lastWrite))
+			if time.Since(lastWrite) >= w.timeouts.inactivity {
+				w.terminate(w.timeouts.inactivityTimeoutReason())
+				return
+			}
+		}
+	}
+}
+
+// terminate interrupts the process group of the command, and kills it if it does not exit in the grace period.
+func (w *watchdog) terminate(reason string) {
+	w.reason = reason
+
+	logger.Println()
+	logger.Errorf("Terminating calabash-android: %s", reason)
+
+	if err := cmdExecutor.Signal(w.cmd, syscall.SIGINT); err != nil {
+		logger.Warnf("Failed to interrupt calabash-android, error: %s", err)
+	}
+
+	select {
+	case <-w.done:
+		return
+	case <-time.After(w.gracePeriod):
+	}
+
+	logger.Warnf("calabash-android did not exit in %s, killing it", w.gracePeriod)
+	if err := cmdExecutor.Signal(w.cmd, syscall.SIGKILL); err != nil {
+		logger.Warnf("Failed to kill calabash-android, error: %s", err)
+	}
+}